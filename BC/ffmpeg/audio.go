@@ -10,11 +10,15 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/utils"
 )
 
 // AudioTrackInfo represents an audio stream
 type AudioTrackInfo struct {
-	Index    int    `json:"index"`
+	Index    int    `json:"index"`    // absolute stream index
+	Position int    `json:"position"` // audio-relative index, as used by -map 0:a:N
 	Language string `json:"language"`
 	Title    string `json:"title"`
 	Codec    string `json:"codec_name"`
@@ -22,8 +26,8 @@ type AudioTrackInfo struct {
 }
 
 // ScanAudioTracks scans all audio tracks in a video file
-func ScanAudioTracks(file string) ([]AudioTrackInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func ScanAudioTracks(ctx context.Context, file string) ([]AudioTrackInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "ffprobe",
@@ -31,6 +35,7 @@ func ScanAudioTracks(file string) ([]AudioTrackInfo, error) {
 		"-select_streams", "a",
 		"-show_entries", "stream=index,codec_name,channels:stream_tags=language,title",
 		"-of", "json", file)
+	hideWindow(cmd)
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -54,9 +59,10 @@ func ScanAudioTracks(file string) ([]AudioTrackInfo, error) {
 	}
 
 	var tracks []AudioTrackInfo
-	for _, s := range data.Streams {
+	for i, s := range data.Streams {
 		tracks = append(tracks, AudioTrackInfo{
 			Index:    s.Index,
+			Position: i,
 			Language: s.Tags.Language,
 			Title:    s.Tags.Title,
 			Codec:    s.CodecName,
@@ -67,9 +73,132 @@ func ScanAudioTracks(file string) ([]AudioTrackInfo, error) {
 	return tracks, nil
 }
 
+// AudioPlan is a resolved audio selection for one source file
+type AudioPlan struct {
+	Tracks  []int // audio-relative indexes in the source, in output order
+	Default int   // output-relative index of the default track
+}
+
+// ValidateAudioSelection checks the parts of an audio selection that do not depend on the file:
+// a known mode, at least one index or language, no negative indexes and nothing selected twice
+func ValidateAudioSelection(sel models.AudioSelection) error {
+	switch strings.ToLower(sel.Mode) {
+	case "", "all":
+	case "index":
+		if len(sel.Indexes) == 0 {
+			return fmt.Errorf("audio mode \"index\" needs at least one index")
+		}
+		seen := make(map[int]bool)
+		for _, idx := range sel.Indexes {
+			if idx < 0 {
+				return fmt.Errorf("audio track index %d must not be negative", idx)
+			}
+			if seen[idx] {
+				return fmt.Errorf("audio track %d is selected twice", idx)
+			}
+			seen[idx] = true
+		}
+	case "language":
+		if len(sel.Languages) == 0 {
+			return fmt.Errorf("audio mode \"language\" needs at least one language")
+		}
+		return validateLanguages("audio", sel.Languages)
+	default:
+		return fmt.Errorf("unknown audio mode %q", sel.Mode)
+	}
+	return nil
+}
+
+// validateLanguages rejects empty language codes and codes listed twice (ignoring case)
+func validateLanguages(kind string, languages []string) error {
+	seen := make(map[string]bool)
+	for _, lang := range languages {
+		code := strings.ToLower(strings.TrimSpace(lang))
+		if code == "" {
+			return fmt.Errorf("%s languages must not be empty", kind)
+		}
+		if seen[code] {
+			return fmt.Errorf("%s language %q is selected twice", kind, lang)
+		}
+		seen[code] = true
+	}
+	return nil
+}
+
+// ResolveAudioPlan picks the tracks to keep according to sel. defaultIndex is the preferred
+// default track (audio-relative in the source); if it is not kept, the first kept track is used
+func ResolveAudioPlan(tracks []AudioTrackInfo, sel models.AudioSelection, defaultIndex int) (AudioPlan, error) {
+	plan := AudioPlan{}
+	if err := ValidateAudioSelection(sel); err != nil {
+		return plan, err
+	}
+	switch strings.ToLower(sel.Mode) {
+	case "", "all":
+		for _, t := range tracks {
+			plan.Tracks = append(plan.Tracks, t.Position)
+		}
+	case "index":
+		for _, idx := range sel.Indexes {
+			if idx >= len(tracks) {
+				return plan, fmt.Errorf("audio track %d not found (file has %d audio tracks)", idx, len(tracks))
+			}
+			plan.Tracks = append(plan.Tracks, idx)
+		}
+	case "language":
+		for _, lang := range sel.Languages {
+			for _, t := range tracks {
+				if strings.EqualFold(trackLanguage(t.Language), strings.TrimSpace(lang)) {
+					plan.Tracks = append(plan.Tracks, t.Position)
+				}
+			}
+		}
+		if len(plan.Tracks) == 0 {
+			return plan, fmt.Errorf("no audio track matches languages %v", sel.Languages)
+		}
+	}
+
+	for k, idx := range plan.Tracks {
+		if idx == defaultIndex {
+			plan.Default = k
+			break
+		}
+	}
+	return plan, nil
+}
+
+// SourceMapArgs maps the planned audio tracks from input 0 of an ffmpeg command
+func (p AudioPlan) SourceMapArgs() []string {
+	args := []string{}
+	for _, idx := range p.Tracks {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", idx))
+	}
+	return args
+}
+
+// DispositionArgs marks the default output audio track and clears the flag on the others
+func (p AudioPlan) DispositionArgs() []string {
+	args := []string{}
+	for k := range p.Tracks {
+		disposition := "0"
+		if k == p.Default {
+			disposition = "default"
+		}
+		args = append(args, fmt.Sprintf("-disposition:a:%d", k), disposition)
+	}
+	return args
+}
+
+// trackLanguage returns the language tag, using "und" for untagged tracks
+func trackLanguage(lang string) string {
+	if lang == "" {
+		return "und"
+	}
+	return lang
+}
+
 // ExtractAudio extracts a specific audio track to a file
-func ExtractAudio(inputFile string, trackIndex int, outputFile string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+func ExtractAudio(ctx context.Context, inputFile string, trackIndex int, outputFile string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
 	// Determine output format based on extension or default to AAC
@@ -108,15 +237,39 @@ func ExtractAudio(inputFile string, trackIndex int, outputFile string) error {
 
 	out, err := RunCmd(ctx, "ffmpeg", args...)
 	if err != nil {
-		return fmt.Errorf("audio extraction failed: %v (%s)", err, string(out))
+		return fmt.Errorf("audio extraction failed: %v (%s)", err, OutputTail(out))
 	}
 
 	return nil
 }
 
-// ExtractAllAudioTracks extracts all audio tracks from a video file
-func ExtractAllAudioTracks(videoFile, outputDir string) (map[int]string, error) {
-	tracks, err := ScanAudioTracks(videoFile)
+// AudioExportExt maps an export format (copy/mka, aac, opus, flac, mp3) to the file extension
+// ExtractAudio uses to pick the codec
+func AudioExportExt(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "copy", "mka":
+		return ".mka", nil
+	case "aac":
+		return ".aac", nil
+	case "opus":
+		return ".opus", nil
+	case "flac":
+		return ".flac", nil
+	case "mp3":
+		return ".mp3", nil
+	}
+	return "", fmt.Errorf("unknown audio export format %q", format)
+}
+
+// ExtractAllAudioTracks extracts all audio tracks from a video file into outputDir/audios as
+// <namePrefix>_<lang>_<title>.<ext>; namePrefix defaults to the video's base name.
+// It returns the written file per absolute stream index
+func ExtractAllAudioTracks(ctx context.Context, videoFile, outputDir, namePrefix, format string) (map[int]string, error) {
+	ext, err := AudioExportExt(format)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := ScanAudioTracks(ctx, videoFile)
 	if err != nil {
 		return nil, err
 	}
@@ -128,10 +281,15 @@ func ExtractAllAudioTracks(videoFile, outputDir string) (map[int]string, error)
 
 	// Create audios subfolder
 	audiosDir := filepath.Join(outputDir, "audios")
-	os.MkdirAll(audiosDir, 0755)
+	if err := os.MkdirAll(audiosDir, 0755); err != nil {
+		return nil, err
+	}
 
 	extractedFiles := make(map[int]string)
-	baseName := strings.TrimSuffix(filepath.Base(videoFile), filepath.Ext(videoFile))
+	if namePrefix == "" {
+		namePrefix = strings.TrimSuffix(filepath.Base(videoFile), filepath.Ext(videoFile))
+	}
+	used := make(map[string]bool)
 
 	for _, track := range tracks {
 		lang := track.Language
@@ -144,13 +302,20 @@ func ExtractAllAudioTracks(videoFile, outputDir string) (map[int]string, error)
 			title = track.Codec
 		}
 		// Clean title for filename
-		title = strings.ReplaceAll(title, " ", "_")
-		title = strings.ReplaceAll(title, "/", "_")
+		title = utils.SanitizeFilename(title)
 
-		audioFile := filepath.Join(audiosDir, fmt.Sprintf("%s_%s_%s_track%d.mka", baseName, lang, title, track.Index))
+		name := fmt.Sprintf("%s_%s_%s", namePrefix, lang, title)
+		if used[name] {
+			name = fmt.Sprintf("%s_track%d", name, track.Index)
+		}
+		used[name] = true
+		audioFile := filepath.Join(audiosDir, name+ext)
 
-		err := ExtractAudio(videoFile, track.Index, audioFile)
+		err := ExtractAudio(ctx, videoFile, track.Index, audioFile)
 		if err != nil {
+			if ctx.Err() != nil {
+				return extractedFiles, ctx.Err()
+			}
 			log.Printf("⚠️ Failed to extract audio track %d: %v", track.Index, err)
 			continue
 		}
@@ -0,0 +1,85 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+var testTracks = []AudioTrackInfo{
+	{Index: 1, Position: 0, Language: "jpn"},
+	{Index: 2, Position: 1, Language: "eng"},
+	{Index: 3, Position: 2, Language: "ENG", Title: "Commentary"},
+	{Index: 4, Position: 3},
+}
+
+func TestResolveAudioPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		sel        models.AudioSelection
+		defaultIdx int
+		want       AudioPlan
+	}{
+		{"all keeps every track", models.AudioSelection{}, 1, AudioPlan{Tracks: []int{0, 1, 2, 3}, Default: 1}},
+		{"index keeps order", models.AudioSelection{Mode: "index", Indexes: []int{2, 0}}, 0, AudioPlan{Tracks: []int{2, 0}, Default: 1}},
+		{"index default not kept", models.AudioSelection{Mode: "index", Indexes: []int{2}}, 0, AudioPlan{Tracks: []int{2}, Default: 0}},
+		{"language groups by order", models.AudioSelection{Mode: "language", Languages: []string{"eng", "jpn"}}, 1, AudioPlan{Tracks: []int{1, 2, 0}, Default: 0}},
+		{"language honours the chosen default", models.AudioSelection{Mode: "language", Languages: []string{"eng", "jpn"}}, 0, AudioPlan{Tracks: []int{1, 2, 0}, Default: 2}},
+		{"language default not kept", models.AudioSelection{Mode: "language", Languages: []string{"eng"}}, 0, AudioPlan{Tracks: []int{1, 2}, Default: 0}},
+		{"language und", models.AudioSelection{Mode: "language", Languages: []string{"und"}}, 0, AudioPlan{Tracks: []int{3}, Default: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveAudioPlan(testTracks, tt.sel, tt.defaultIdx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ResolveAudioPlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAudioPlanErrors(t *testing.T) {
+	for _, sel := range []models.AudioSelection{
+		{Mode: "index"},
+		{Mode: "index", Indexes: []int{4}},
+		{Mode: "language"},
+		{Mode: "language", Languages: []string{"fre"}},
+		{Mode: "index", Indexes: []int{-1}},
+		{Mode: "index", Indexes: []int{1, 0, 1}},
+		{Mode: "language", Languages: []string{"eng", " ENG"}},
+		{Mode: "language", Languages: []string{""}},
+		{Mode: "loudest"},
+	} {
+		if _, err := ResolveAudioPlan(testTracks, sel, 0); err == nil {
+			t.Errorf("ResolveAudioPlan(%+v) expected error", sel)
+		}
+	}
+}
+
+func TestAudioPlanArgs(t *testing.T) {
+	plan := AudioPlan{Tracks: []int{2, 0}, Default: 1}
+	wantMap := []string{"-map", "0:a:2", "-map", "0:a:0"}
+	if got := plan.SourceMapArgs(); !reflect.DeepEqual(got, wantMap) {
+		t.Errorf("SourceMapArgs() = %v, want %v", got, wantMap)
+	}
+	wantDisp := []string{"-disposition:a:0", "0", "-disposition:a:1", "default"}
+	if got := plan.DispositionArgs(); !reflect.DeepEqual(got, wantDisp) {
+		t.Errorf("DispositionArgs() = %v, want %v", got, wantDisp)
+	}
+}
+
+func TestAudioExportExt(t *testing.T) {
+	for format, want := range map[string]string{"": ".mka", "copy": ".mka", "MKA": ".mka", "aac": ".aac", "opus": ".opus", "flac": ".flac", "mp3": ".mp3"} {
+		got, err := AudioExportExt(format)
+		if err != nil || got != want {
+			t.Errorf("AudioExportExt(%q) = %q, %v; want %q", format, got, err, want)
+		}
+	}
+	if _, err := AudioExportExt("wav"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
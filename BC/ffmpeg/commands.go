@@ -5,32 +5,46 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
-	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/sanke08/videoprocessor/models"
 	"github.com/sanke08/videoprocessor/utils"
 )
 
-// RunCmd executes a command with context and timeout
+// RunCmd executes a command with context and timeout; the process is killed when ctx is done
 func RunCmd(ctx context.Context, name string, args ...string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	// make windows hide window if available
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	hideWindow(cmd)
 	return cmd.CombinedOutput()
 }
 
+// outputTailLines is how much of a failing command's output is kept in its error
+const outputTailLines = 8
+
+// OutputTail returns the last lines of a command's output; ffmpeg prints the actual error last,
+// after the banner and stream listing
+func OutputTail(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > outputTailLines {
+		lines = lines[len(lines)-outputTailLines:]
+	}
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // GetDuration gets the duration of a video file using ffprobe
-func GetDuration(path string) (float64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func GetDuration(ctx context.Context, path string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 	out, err := RunCmd(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1", path)
 	if err != nil {
-		return 0, fmt.Errorf("ffprobe duration failed: %v (%s)", err, string(out))
+		return 0, fmt.Errorf("ffprobe duration failed: %v (%s)", err, OutputTail(out))
 	}
 	s := strings.TrimSpace(string(out))
 	if s == "" {
@@ -44,23 +58,24 @@ func GetDuration(path string) (float64, error) {
 }
 
 // ExtractMetadata extracts ffmetadata from original file to outPath
-func ExtractMetadata(input, outPath string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func ExtractMetadata(ctx context.Context, input, outPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 	// ffmpeg -y -i input -f ffmetadata outPath
 	out, err := RunCmd(ctx, "ffmpeg", "-y", "-i", input, "-f", "ffmetadata", outPath)
 	if err != nil {
 		// return with output so caller can inspect
-		return fmt.Errorf("ffmpeg extract metadata failed: %v (%s)", err, string(out))
+		return fmt.Errorf("ffmpeg extract metadata failed: %v (%s)", err, OutputTail(out))
 	}
 	return nil
 }
 
 // ScanChapters scans chapters from a single file
-func ScanChapters(file string) (models.Chapters, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func ScanChapters(ctx context.Context, file string) (models.Chapters, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_chapters", "-of", "json", file)
+	hideWindow(cmd)
 	out, err := cmd.Output()
 	if err != nil {
 		return nil, err
@@ -86,8 +101,9 @@ func ScanChapters(file string) (models.Chapters, error) {
 		chapters[title] = t
 	}
 	// ensure End exists
-	cmdDur := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration",
+	cmdDur := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	hideWindow(cmdDur)
 	durBytes, _ := cmdDur.Output()
 	dur, _ := strconv.ParseFloat(strings.TrimSpace(string(durBytes)), 64)
 	if dur <= 0 {
@@ -104,28 +120,12 @@ func ScanChapters(file string) (models.Chapters, error) {
 }
 
 // ScanFirstTwoEpisodes scans the first two episodes in a folder for analysis
-func ScanFirstTwoEpisodes(folder string) (*models.ScanResult, error) {
-	// Normalize path for Windows - keep backslashes for Windows commands
-	folder = strings.TrimSpace(folder)
-	folder = strings.ReplaceAll(folder, "/", "\\")
-
-	// Use dir command to list .mkv files (Windows style with backslashes)
-	pattern := folder + "\\*.mkv"
-	files, err := exec.Command("cmd", "/c", "dir", "/b", pattern).Output()
+func ScanFirstTwoEpisodes(ctx context.Context, folder string) (*models.ScanResult, error) {
+	mkvFiles, err := utils.ListMKVFiles(folder)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list files in %s: %v", folder, err)
 	}
 
-	fileList := strings.Split(strings.TrimSpace(string(files)), "\n")
-	var mkvFiles []string
-	for _, f := range fileList {
-		f = strings.TrimSpace(f)
-		f = strings.ReplaceAll(f, "\r", "") // Remove carriage return
-		if f != "" {
-			mkvFiles = append(mkvFiles, folder+"\\"+f)
-		}
-	}
-
 	if len(mkvFiles) < 2 {
 		return nil, fmt.Errorf("less than 2 MKV files found in %s", folder)
 	}
@@ -134,15 +134,11 @@ func ScanFirstTwoEpisodes(folder string) (*models.ScanResult, error) {
 	cumulativeTime := 0.0
 	audioTracks := []models.AudioTrack{}
 
-	sort.Slice(mkvFiles, func(i, j int) bool {
-		return utils.NaturalLess(mkvFiles[i], mkvFiles[j])
-	})
-
 	for i := 0; i < utils.Min(2, len(mkvFiles)); i++ {
 		file := mkvFiles[i]
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_chapters", "-of", "json", file)
-		cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+		probeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		cmd := exec.CommandContext(probeCtx, "ffprobe", "-v", "error", "-show_chapters", "-of", "json", file)
+		hideWindow(cmd)
 		out, err := cmd.Output()
 		cancel()
 		if err != nil {
@@ -171,19 +167,17 @@ func ScanFirstTwoEpisodes(folder string) (*models.ScanResult, error) {
 		}
 
 		// Duration
-		cmdDur := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration",
-			"-of", "default=noprint_wrappers=1:nokey=1", file)
-		durBytes, _ := cmdDur.Output()
-		dur, _ := strconv.ParseFloat(strings.TrimSpace(string(durBytes)), 64)
+		dur, _ := GetDuration(ctx, file)
 		cumulativeTime += dur
 
 		if i == 0 {
 			// Audio tracks of first file
-			ctxAudio, cancelAudio := context.WithTimeout(context.Background(), 30*time.Second)
+			ctxAudio, cancelAudio := context.WithTimeout(ctx, 30*time.Second)
 			cmdAudio := exec.CommandContext(ctxAudio, "ffprobe",
 				"-v", "error", "-select_streams", "a",
 				"-show_entries", "stream=index:stream_tags=language,title",
 				"-of", "json", file)
+			hideWindow(cmdAudio)
 			outAudio, err := cmdAudio.Output()
 			cancelAudio()
 			if err == nil {
@@ -197,9 +191,9 @@ func ScanFirstTwoEpisodes(folder string) (*models.ScanResult, error) {
 					} `json:"streams"`
 				}
 				if err := json.Unmarshal(outAudio, &audioData); err == nil {
-					for _, s := range audioData.Streams {
+					for pos, s := range audioData.Streams {
 						audioTracks = append(audioTracks, models.AudioTrack{
-							Index: s.Index - 1,
+							Index: pos, // audio-relative, matches TrimOptions.AudioIndex
 							Lang:  s.Tags.Language,
 							Title: s.Tags.Title,
 						})
@@ -209,10 +203,23 @@ func ScanFirstTwoEpisodes(folder string) (*models.ScanResult, error) {
 		}
 	}
 
+	subtitleTracks := []models.SubtitleTrackInfo{}
+	if subs, err := ScanSubtitles(ctx, mkvFiles[0]); err == nil {
+		for _, t := range subs {
+			subtitleTracks = append(subtitleTracks, models.SubtitleTrackInfo{
+				Index: t.Position,
+				Lang:  t.Language,
+				Title: t.Title,
+				Codec: t.Codec,
+			})
+		}
+	}
+
 	chapters["End"] = cumulativeTime
 	return &models.ScanResult{
-		Chapters:    chapters,
-		AudioTracks: audioTracks,
-		FirstFile:   mkvFiles[0],
+		Chapters:       chapters,
+		AudioTracks:    audioTracks,
+		SubtitleTracks: subtitleTracks,
+		FirstFile:      mkvFiles[0],
 	}, nil
 }
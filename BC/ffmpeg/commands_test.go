@@ -0,0 +1,47 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanFirstTwoEpisodesNeedsTwoFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Episode 1.mkv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ScanFirstTwoEpisodes(context.Background(), dir)
+	if err == nil || !strings.Contains(err.Error(), "less than 2 MKV files") {
+		t.Fatalf("expected 'less than 2 MKV files' error, got %v", err)
+	}
+}
+
+func TestScanFirstTwoEpisodesMissingFolder(t *testing.T) {
+	_, err := ScanFirstTwoEpisodes(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	if err == nil || !strings.Contains(err.Error(), "failed to list files") {
+		t.Fatalf("expected list error, got %v", err)
+	}
+}
+
+func TestOutputTail(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, "  line "+strings.Repeat("x", i%3))
+	}
+	out := []byte(strings.Join(lines, "\n") + "\nError opening output file\n\n")
+
+	tail := strings.Split(OutputTail(out), "\n")
+	if len(tail) != outputTailLines {
+		t.Fatalf("got %d lines, want %d: %q", len(tail), outputTailLines, tail)
+	}
+	if tail[len(tail)-1] != "Error opening output file" {
+		t.Errorf("last line = %q, want the ffmpeg error", tail[len(tail)-1])
+	}
+	if OutputTail([]byte("short\n")) != "short" {
+		t.Errorf("short output should be kept as is")
+	}
+}
@@ -0,0 +1,294 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// Concat policies of TrimOptions.ConcatPolicy, applied when the files of a part differ in stream parameters
+const (
+	ConcatRefuse   = "refuse"   // leave the part out of the job and report the mismatches
+	ConcatReencode = "reencode" // re-encode the outlier files to match the rest of the part
+	ConcatFilter   = "filter"   // join the part with the concat filter, re-encoding everything with a preset
+)
+
+// ResolveConcatPolicy validates the concat policy of a request, defaulting to refuse
+func ResolveConcatPolicy(opts models.TrimOptions) (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(opts.ConcatPolicy)); policy {
+	case "":
+		return ConcatRefuse, nil
+	case ConcatRefuse, ConcatReencode, ConcatFilter:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown concat policy %q (use refuse, reencode or filter)", opts.ConcatPolicy)
+	}
+}
+
+// StreamParams are the parameters of a stream that have to agree across files joined by the concat demuxer
+type StreamParams struct {
+	Index         int    `json:"index"`
+	Type          string `json:"codec_type"`
+	Codec         string `json:"codec_name"`
+	Profile       string `json:"profile"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	PixFmt        string `json:"pix_fmt"`
+	SampleRate    string `json:"sample_rate"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout"`
+	TimeBase      string `json:"time_base"`
+	Level         int    `json:"level"`
+}
+
+// ProbeStreams reads the parameters of the video, audio and subtitle streams of file in stream order
+func ProbeStreams(ctx context.Context, file string) ([]StreamParams, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := RunCmd(ctx, "ffprobe", "-v", "error",
+		"-show_entries", "stream=index,codec_type,codec_name,profile,width,height,pix_fmt,sample_rate,channels,channel_layout,time_base,level",
+		"-of", "json", file)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe streams failed: %v (%s)", err, OutputTail(out))
+	}
+	var data struct {
+		Streams []StreamParams `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %v", err)
+	}
+	streams := make([]StreamParams, 0, len(data.Streams))
+	for _, s := range data.Streams {
+		switch s.Type {
+		case "video", "audio", "subtitle":
+			streams = append(streams, s)
+		}
+	}
+	return streams, nil
+}
+
+// streamsOfType returns the streams of the given codec type in stream order
+func streamsOfType(streams []StreamParams, kind string) []StreamParams {
+	var out []StreamParams
+	for _, s := range streams {
+		if s.Type == kind {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// CompareStreams describes every way the streams of a file differ from the reference streams,
+// comparing the n-th stream of each type. It returns nil when the two can be stream-copy concatenated
+func CompareStreams(ref, other []StreamParams) []string {
+	var diffs []string
+	differ := func(kind string, k int, what, got, want string) {
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("%s %d: %s %s, expected %s", kind, k, what, orNone(got), orNone(want)))
+		}
+	}
+	for _, kind := range []string{"video", "audio", "subtitle"} {
+		refs, others := streamsOfType(ref, kind), streamsOfType(other, kind)
+		if len(refs) != len(others) {
+			diffs = append(diffs, fmt.Sprintf("%d %s stream(s), expected %d", len(others), kind, len(refs)))
+			continue
+		}
+		for k := range refs {
+			r, o := refs[k], others[k]
+			differ(kind, k, "codec", o.Codec, r.Codec)
+			switch kind {
+			case "video":
+				differ(kind, k, "profile", o.Profile, r.Profile)
+				differ(kind, k, "resolution", fmt.Sprintf("%dx%d", o.Width, o.Height), fmt.Sprintf("%dx%d", r.Width, r.Height))
+				differ(kind, k, "pixel format", o.PixFmt, r.PixFmt)
+				differ(kind, k, "timebase", o.TimeBase, r.TimeBase)
+			case "audio":
+				differ(kind, k, "profile", o.Profile, r.Profile)
+				differ(kind, k, "sample rate", o.SampleRate, r.SampleRate)
+				differ(kind, k, "channels", fmt.Sprint(o.Channels), fmt.Sprint(r.Channels))
+				differ(kind, k, "channel layout", o.ChannelLayout, r.ChannelLayout)
+				differ(kind, k, "timebase", o.TimeBase, r.TimeBase)
+			}
+		}
+	}
+	return diffs
+}
+
+// orNone names empty parameter values in mismatch descriptions
+func orNone(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+// SameStreamCounts reports whether two files carry the same number of video, audio and subtitle streams,
+// which re-encoding or the concat filter cannot make up for
+func SameStreamCounts(ref, other []StreamParams) bool {
+	for _, kind := range []string{"video", "audio", "subtitle"} {
+		if len(streamsOfType(ref, kind)) != len(streamsOfType(other, kind)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReferenceStreams picks the stream layout shared by most files, preferring the earliest file on a tie,
+// and returns that file's index. The other files are compared against it
+func ReferenceStreams(probes [][]StreamParams) int {
+	best, bestCount := 0, 0
+	for i := range probes {
+		count := 0
+		for j := range probes {
+			if len(CompareStreams(probes[i], probes[j])) == 0 {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = i, count
+		}
+	}
+	return best
+}
+
+// audioEncoders maps audio codec names to the ffmpeg encoder that produces them
+var audioEncoders = map[string]string{
+	"aac":    "aac",
+	"ac3":    "ac3",
+	"eac3":   "eac3",
+	"flac":   "flac",
+	"mp3":    "libmp3lame",
+	"opus":   "libopus",
+	"vorbis": "libvorbis",
+}
+
+// conformArgs returns codec arguments that re-encode a file's video and audio to the reference parameters.
+// They follow a "-c copy" so subtitles are still copied
+func conformArgs(ref []StreamParams) ([]string, error) {
+	var args []string
+	if videos := streamsOfType(ref, "video"); len(videos) > 0 {
+		v := videos[0]
+		encoder, err := smartEncoderArgs(VideoStreamInfo{Codec: v.Codec, Profile: v.Profile, PixFmt: v.PixFmt, Level: v.Level})
+		if err != nil {
+			return nil, fmt.Errorf("cannot re-encode to %q video", v.Codec)
+		}
+		args = append(args, encoder...)
+		args = append(args, "-filter:v:0", scaleFilter(v.Width, v.Height))
+	}
+	for k, a := range streamsOfType(ref, "audio") {
+		encoder, ok := audioEncoders[a.Codec]
+		if !ok {
+			return nil, fmt.Errorf("cannot re-encode to %q audio", a.Codec)
+		}
+		args = append(args, fmt.Sprintf("-c:a:%d", k), encoder)
+		if a.SampleRate != "" {
+			args = append(args, fmt.Sprintf("-ar:a:%d", k), a.SampleRate)
+		}
+		if a.Channels > 0 {
+			args = append(args, fmt.Sprintf("-ac:a:%d", k), fmt.Sprint(a.Channels))
+		}
+	}
+	return args, nil
+}
+
+// scaleFilter fits a picture into width x height, letterboxing to keep its aspect ratio
+func scaleFilter(width, height int) string {
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1", width, height, width, height)
+}
+
+// ConformFile re-encodes the video and audio of file to the reference stream parameters so it can be
+// stream-copy concatenated with files that have them. Subtitles are copied. The encoder cannot reproduce
+// every reference (e.g. HE-AAC audio), so callers should compare the result with CompareStreams
+func ConformFile(ctx context.Context, file, out string, ref []StreamParams, onProgress ProgressFunc) error {
+	codecArgs, err := conformArgs(ref)
+	if err != nil {
+		return err
+	}
+	encodeCtx, cancel := context.WithTimeout(ctx, 3*time.Hour)
+	defer cancel()
+	args := []string{"-y", "-i", file}
+	args = append(args, CopyMapArgs()...)
+	args = append(args, "-ignore_unknown", "-c", "copy")
+	args = append(args, codecArgs...)
+	outb, err := RunFFmpeg(encodeCtx, onProgress, append(args, out)...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg conform failed: %v (%s)", err, OutputTail(outb))
+	}
+	return nil
+}
+
+// ConcatFilterArgs returns the ffmpeg arguments (without the output) that join files with the concat
+// filter: every file is scaled to the reference resolution and its audio resampled to the reference
+// layout, then everything is encoded with preset. Subtitles cannot pass through the filter and are dropped
+func ConcatFilterArgs(files []string, ref []StreamParams, preset EncodePreset) []string {
+	width, height := 0, 0
+	if videos := streamsOfType(ref, "video"); len(videos) > 0 {
+		width, height = videos[0].Width, videos[0].Height
+	}
+	audios := streamsOfType(ref, "audio")
+
+	args := []string{"-y"}
+	var graph, inputs strings.Builder
+	for i, f := range files {
+		args = append(args, "-i", f)
+		fmt.Fprintf(&graph, "[%d:v:0]%s[v%d];", i, scaleFilter(width, height), i)
+		fmt.Fprintf(&inputs, "[v%d]", i)
+		for k, a := range audios {
+			fmt.Fprintf(&graph, "[%d:a:%d]%s[a%d_%d];", i, k, audioConformFilter(a), i, k)
+			fmt.Fprintf(&inputs, "[a%d_%d]", i, k)
+		}
+	}
+	fmt.Fprintf(&graph, "%sconcat=n=%d:v=1:a=%d[v]", inputs.String(), len(files), len(audios))
+	for k := range audios {
+		fmt.Fprintf(&graph, "[a%d]", k)
+	}
+
+	args = append(args, "-filter_complex", graph.String(), "-map", "[v]")
+	for k := range audios {
+		args = append(args, "-map", fmt.Sprintf("[a%d]", k))
+	}
+	return append(args, preset.Args()...)
+}
+
+// audioConformFilter resamples an audio stream to the reference sample rate and channel layout. Parameters
+// ffprobe did not report are left alone rather than producing an invalid filter
+func audioConformFilter(a StreamParams) string {
+	var filters []string
+	if a.SampleRate != "" {
+		filters = append(filters, "aresample="+a.SampleRate)
+	}
+	layout := a.ChannelLayout
+	if layout == "" && a.Channels > 0 {
+		layout = fmt.Sprintf("%dc", a.Channels)
+	}
+	if layout != "" {
+		filters = append(filters, "aformat=channel_layouts="+layout)
+	}
+	if len(filters) == 0 {
+		return "anull"
+	}
+	return strings.Join(filters, ",")
+}
+
+// InbandHeaders stream-copies file to out, writing the video's parameter sets in front of every keyframe
+// so the file can be concatenated with files encoded with other parameter sets
+func InbandHeaders(ctx context.Context, file, out, codec string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+	args := []string{"-y", "-i", file}
+	args = append(args, CopyMapArgs()...)
+	args = append(args, "-ignore_unknown", "-c", "copy")
+	args = append(args, inbandHeaderArgs(codec)...)
+	outb, err := RunCmd(ctx, "ffmpeg", append(args, out)...)
+	if err != nil {
+		return fmt.Errorf("ffmpeg remux failed: %v (%s)", err, OutputTail(outb))
+	}
+	return nil
+}
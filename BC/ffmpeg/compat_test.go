@@ -0,0 +1,185 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveConcatPolicy(t *testing.T) {
+	for in, want := range map[string]string{"": ConcatRefuse, "refuse": ConcatRefuse, " Reencode ": ConcatReencode, "filter": ConcatFilter} {
+		if got, err := ResolveConcatPolicy(models.TrimOptions{ConcatPolicy: in}); err != nil || got != want {
+			t.Errorf("ResolveConcatPolicy(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ResolveConcatPolicy(models.TrimOptions{ConcatPolicy: "ignore"}); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+// testStreams is a 1080p H.264 video with a stereo AAC track and an ASS subtitle
+func testStreams() []StreamParams {
+	return []StreamParams{
+		{Index: 0, Type: "video", Codec: "h264", Profile: "High", Width: 1920, Height: 1080, PixFmt: "yuv420p", TimeBase: "1/1000", Level: 40},
+		{Index: 1, Type: "audio", Codec: "aac", Profile: "LC", SampleRate: "48000", Channels: 2, ChannelLayout: "stereo", TimeBase: "1/1000"},
+		{Index: 2, Type: "subtitle", Codec: "ass", TimeBase: "1/1000"},
+	}
+}
+
+func TestCompareStreams(t *testing.T) {
+	ref := testStreams()
+	if diffs := CompareStreams(ref, testStreams()); diffs != nil {
+		t.Errorf("identical streams differ: %v", diffs)
+	}
+
+	other := testStreams()
+	other[0].Width, other[0].Height = 1280, 720
+	other[1].Channels, other[1].ChannelLayout = 6, "5.1(side)"
+	want := []string{
+		"video 0: resolution 1280x720, expected 1920x1080",
+		"audio 0: channels 6, expected 2",
+		"audio 0: channel layout 5.1(side), expected stereo",
+	}
+	if diffs := CompareStreams(ref, other); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("CompareStreams = %q, want %q", diffs, want)
+	}
+
+	noSubs := testStreams()[:2]
+	if diffs := CompareStreams(ref, noSubs); !reflect.DeepEqual(diffs, []string{"0 subtitle stream(s), expected 1"}) {
+		t.Errorf("missing subtitle: %q", diffs)
+	}
+	if SameStreamCounts(ref, noSubs) || !SameStreamCounts(ref, other) {
+		t.Error("SameStreamCounts compares the wrong streams")
+	}
+}
+
+func TestReferenceStreams(t *testing.T) {
+	odd := testStreams()
+	odd[0].Profile = "Main"
+	if got := ReferenceStreams([][]StreamParams{odd, testStreams(), testStreams()}); got != 1 {
+		t.Errorf("ReferenceStreams = %d, want the first file of the majority layout (1)", got)
+	}
+	if got := ReferenceStreams([][]StreamParams{odd, testStreams()}); got != 0 {
+		t.Errorf("ReferenceStreams on a tie = %d, want 0", got)
+	}
+}
+
+func TestConformArgs(t *testing.T) {
+	args, err := conformArgs(testStreams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	for _, want := range []string{"-c:v:0 libx264", "-profile:v:0 high", "-level:v:0 4.0", "repeat-headers=1", "-filter:v:0 scale=1920:1080", "-c:a:0 aac", "-ar:a:0 48000", "-ac:a:0 2"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args %q lack %q", joined, want)
+		}
+	}
+	bad := testStreams()
+	bad[1].Codec = "truehd"
+	if _, err := conformArgs(bad); err == nil {
+		t.Error("expected an error for an audio codec without encoder")
+	}
+}
+
+func TestConcatFilterArgs(t *testing.T) {
+	args := ConcatFilterArgs([]string{"a.mkv", "b.mkv"}, testStreams(), Presets["x264-fast"])
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"-i a.mkv -i b.mkv",
+		"[1:a:0]aresample=48000,aformat=channel_layouts=stereo[a1_0]",
+		"[v0][a0_0][v1][a1_0]concat=n=2:v=1:a=1[v][a0]",
+		"-map [v] -map [a0]",
+		"-c:v:0 libx264",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args %q lack %q", joined, want)
+		}
+	}
+
+	// parameters ffprobe did not report are not resampled to
+	unknown := testStreams()
+	unknown[1].SampleRate = ""
+	joined = strings.Join(ConcatFilterArgs([]string{"a.mkv"}, unknown, Presets["x264-fast"]), " ")
+	if strings.Contains(joined, "aresample") || !strings.Contains(joined, "[0:a:0]aformat=channel_layouts=stereo[a0_0]") {
+		t.Errorf("args %q resample to an unknown rate", joined)
+	}
+	unknown[1].ChannelLayout, unknown[1].Channels = "", 0
+	joined = strings.Join(ConcatFilterArgs([]string{"a.mkv"}, unknown, Presets["x264-fast"]), " ")
+	if !strings.Contains(joined, "[0:a:0]anull[a0_0]") {
+		t.Errorf("args %q do not pass audio without parameters through", joined)
+	}
+}
+
+func TestConformFileMatchesReference(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	clip := makeTestClip(t, dir)
+	ctx := context.Background()
+
+	small := filepath.Join(dir, "small.mkv")
+	if out, err := exec.Command("ffmpeg", "-v", "error", "-y", "-i", clip, "-vf", "scale=160:120",
+		"-c:v", "libx264", "-preset", "ultrafast", "-c:a", "aac", "-ac", "1", small).CombinedOutput(); err != nil {
+		t.Fatalf("scaling clip failed: %v (%s)", err, out)
+	}
+	ref, err := ProbeStreams(ctx, clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ProbeStreams(ctx, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(CompareStreams(ref, before)) == 0 {
+		t.Fatal("expected the scaled clip to differ")
+	}
+
+	conformed := filepath.Join(dir, "conformed.mkv")
+	if err := ConformFile(ctx, small, conformed, ref, nil); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ProbeStreams(ctx, conformed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := CompareStreams(ref, after); len(diffs) != 0 {
+		t.Errorf("conformed file still differs: %v", diffs)
+	}
+}
+
+func TestConformedFileJoinsWithCopiedFiles(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	clip := makeTestClip(t, dir)
+	ctx := context.Background()
+
+	ref, err := ProbeStreams(ctx, clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformed := filepath.Join(dir, "conformed.mkv")
+	if err := ConformFile(ctx, clip, conformed, ref, nil); err != nil {
+		t.Fatal(err)
+	}
+	inband := filepath.Join(dir, "inband.mkv")
+	if err := InbandHeaders(ctx, clip, inband, "h264"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the conformed file comes first, so the joined file starts with the encoder's parameter sets
+	list := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(list, []byte("file '"+conformed+"'\nfile '"+inband+"'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	joined := filepath.Join(dir, "joined.mkv")
+	if out, err := exec.Command("ffmpeg", "-v", "error", "-y", "-f", "concat", "-safe", "0", "-i", list, "-c", "copy", joined).CombinedOutput(); err != nil {
+		t.Fatalf("concat failed: %v (%s)", err, out)
+	}
+	requireDecodes(t, joined)
+}
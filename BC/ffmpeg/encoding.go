@@ -0,0 +1,120 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// Cut modes of TrimOptions.CutMode
+const (
+	CutCopy     = "copy"     // stream copy, cuts snap to the keyframe before each boundary
+	CutSmart    = "smart"    // re-encode only the GOP fragments at each boundary, stream copy the rest
+	CutReencode = "reencode" // re-encode every segment with an encoding preset
+)
+
+// CutSettings is the resolved way segments are cut
+type CutSettings struct {
+	Mode   string
+	Preset EncodePreset // only set in re-encode mode
+}
+
+// ResolveCutSettings validates the cut mode and encoding preset of a request
+func ResolveCutSettings(opts models.TrimOptions) (CutSettings, error) {
+	mode := strings.ToLower(strings.TrimSpace(opts.CutMode))
+	if opts.Preset != "" && mode != CutReencode {
+		return CutSettings{}, fmt.Errorf("preset %q needs cutMode %q", opts.Preset, CutReencode)
+	}
+	switch mode {
+	case "", CutCopy:
+		return CutSettings{Mode: CutCopy}, nil
+	case CutSmart:
+		return CutSettings{Mode: mode}, nil
+	case CutReencode:
+		name := opts.Preset
+		if name == "" {
+			name = DefaultPreset
+		}
+		preset, ok := Presets[strings.ToLower(name)]
+		if !ok {
+			return CutSettings{}, fmt.Errorf("unknown preset %q (use one of %s)", opts.Preset, strings.Join(PresetNames(), ", "))
+		}
+		return CutSettings{Mode: mode, Preset: preset}, nil
+	default:
+		return CutSettings{}, fmt.Errorf("unknown cut mode %q (use copy, smart or reencode)", opts.CutMode)
+	}
+}
+
+// EncodePreset is a named set of encoder settings for the re-encode cut mode
+type EncodePreset struct {
+	Name         string `json:"name"`
+	VideoCodec   string `json:"videoCodec"` // ffmpeg encoder, e.g. libx264
+	CRF          int    `json:"crf"`
+	Speed        string `json:"speed"`      // encoder -preset, e.g. medium
+	AudioCodec   string `json:"audioCodec"` // ffmpeg encoder, e.g. aac
+	AudioBitrate string `json:"audioBitrate"`
+}
+
+// DefaultPreset is used by the re-encode mode when no preset is named
+const DefaultPreset = "x264-balanced"
+
+// Presets are the encoder settings selectable with TrimOptions.Preset
+var Presets = map[string]EncodePreset{
+	"x264-fast":     {Name: "x264-fast", VideoCodec: "libx264", CRF: 23, Speed: "veryfast", AudioCodec: "aac", AudioBitrate: "160k"},
+	"x264-balanced": {Name: "x264-balanced", VideoCodec: "libx264", CRF: 20, Speed: "medium", AudioCodec: "aac", AudioBitrate: "192k"},
+	"x264-quality":  {Name: "x264-quality", VideoCodec: "libx264", CRF: 17, Speed: "slow", AudioCodec: "aac", AudioBitrate: "256k"},
+	"x265-small":    {Name: "x265-small", VideoCodec: "libx265", CRF: 28, Speed: "medium", AudioCodec: "libopus", AudioBitrate: "96k"},
+	"x265-balanced": {Name: "x265-balanced", VideoCodec: "libx265", CRF: 24, Speed: "medium", AudioCodec: "libopus", AudioBitrate: "128k"},
+	"x265-quality":  {Name: "x265-quality", VideoCodec: "libx265", CRF: 20, Speed: "slow", AudioCodec: "libopus", AudioBitrate: "160k"},
+}
+
+// PresetNames returns the preset names in alphabetical order
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Args returns the codec arguments of the preset. They follow a "-c copy" so subtitles (and
+// attached pictures) are still copied while the main video and all audio streams are encoded
+func (p EncodePreset) Args() []string {
+	return []string{
+		"-c:v:0", p.VideoCodec, "-crf", strconv.Itoa(p.CRF), "-preset", p.Speed, "-pix_fmt:v:0", "yuv420p",
+		"-c:a", p.AudioCodec, "-b:a", p.AudioBitrate,
+	}
+}
+
+// EncodeSegment re-encodes [start, end] of file to out with the preset. Input seeking while
+// decoding makes both boundaries frame accurate
+func EncodeSegment(ctx context.Context, file, out string, start, end float64, streams StreamPlan, preset EncodePreset, onProgress ProgressFunc) error {
+	// encoding is much slower than copying, so allow for long episodes
+	encodeCtx, cancel := context.WithTimeout(ctx, 3*time.Hour)
+	defer cancel()
+	args := []string{
+		"-y",
+		"-ss", fmt.Sprintf("%.6f", start),
+		"-i", file,
+		"-t", fmt.Sprintf("%.6f", end-start),
+	}
+	args = append(args, streams.SourceMapArgs()...)
+	args = append(args, "-ignore_unknown", "-c", "copy")
+	args = append(args, preset.Args()...)
+	args = append(args, "-avoid_negative_ts", "make_zero", "-map_chapters", "-1")
+	args = append(args, streams.Audio.DispositionArgs()...)
+	outb, err := RunFFmpeg(encodeCtx, onProgress, append(args, out)...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg encode failed: %v (%s)", err, OutputTail(outb))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ffmpeg
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveCutSettings(t *testing.T) {
+	tests := []struct {
+		opts       models.TrimOptions
+		wantMode   string
+		wantPreset string
+	}{
+		{models.TrimOptions{}, CutCopy, ""},
+		{models.TrimOptions{CutMode: "copy"}, CutCopy, ""},
+		{models.TrimOptions{CutMode: " Smart "}, CutSmart, ""},
+		{models.TrimOptions{CutMode: "reencode"}, CutReencode, DefaultPreset},
+		{models.TrimOptions{CutMode: "reencode", Preset: "X265-small"}, CutReencode, "x265-small"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveCutSettings(tt.opts)
+		if err != nil || got.Mode != tt.wantMode || got.Preset.Name != tt.wantPreset {
+			t.Errorf("ResolveCutSettings(%+v) = %+v, %v; want %s/%q", tt.opts, got, err, tt.wantMode, tt.wantPreset)
+		}
+	}
+
+	for _, opts := range []models.TrimOptions{
+		{CutMode: "fast"},
+		{CutMode: "reencode", Preset: "av1-tiny"},
+		{CutMode: "copy", Preset: "x264-fast"},
+	} {
+		if _, err := ResolveCutSettings(opts); err == nil {
+			t.Errorf("ResolveCutSettings(%+v): expected an error", opts)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	if _, ok := Presets[DefaultPreset]; !ok {
+		t.Fatalf("default preset %q is not defined", DefaultPreset)
+	}
+	for name, p := range Presets {
+		if p.Name != name {
+			t.Errorf("preset %q is named %q", name, p.Name)
+		}
+	}
+	args := strings.Join(Presets["x265-small"].Args(), " ")
+	for _, want := range []string{"-c:v:0 libx265", "-crf 28", "-preset medium", "-c:a libopus", "-b:a 96k"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+}
+
+func TestEncodeSegmentIsFrameAccurate(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	clip := makeTestClip(t, dir)
+	out := filepath.Join(dir, "encoded.mkv")
+
+	streams := StreamPlan{Audio: AudioPlan{Tracks: []int{0}}}
+	if err := EncodeSegment(context.Background(), clip, out, 3.3, 7.7, streams, Presets["x264-fast"], nil); err != nil {
+		t.Fatal(err)
+	}
+	if frames := countVideoFrames(t, out); frames < 109 || frames > 111 {
+		t.Errorf("encoded segment has %d frames, want 110±1", frames)
+	}
+	codec, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=codec_name", "-of", "csv=p=0", out).Output()
+	if err != nil || strings.TrimSpace(string(codec)) != "aac" {
+		t.Errorf("audio codec = %q (%v), want aac", codec, err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package ffmpeg
+
+import "os/exec"
+
+// hideWindow is a no-op outside Windows
+func hideWindow(cmd *exec.Cmd) {}
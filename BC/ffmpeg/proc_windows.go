@@ -0,0 +1,13 @@
+//go:build windows
+
+package ffmpeg
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// hideWindow stops child processes from opening a console window on Windows
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+}
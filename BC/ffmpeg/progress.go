@@ -0,0 +1,77 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProgressReport is one block of ffmpeg -progress output
+type ProgressReport struct {
+	OutTime float64 // seconds of media written so far
+	Speed   float64 // processing speed as a multiple of realtime, 0 when unknown
+	Size    int64   // bytes written so far
+	End     bool    // the final report of the run
+}
+
+// ProgressFunc receives progress reports while ffmpeg runs
+type ProgressFunc func(ProgressReport)
+
+// RunFFmpeg runs ffmpeg like RunCmd, additionally reading its -progress output and passing every
+// report to onProgress. The returned output is ffmpeg's stderr. A nil onProgress behaves like RunCmd
+func RunFFmpeg(ctx context.Context, onProgress ProgressFunc, args ...string) ([]byte, error) {
+	if onProgress == nil {
+		return RunCmd(ctx, "ffmpeg", args...)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-progress", "pipe:1", "-nostats"}, args...)...)
+	hideWindow(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// read until ffmpeg closes stdout so Wait does not race the pipe
+	_ = ParseProgress(stdout, onProgress)
+	err = cmd.Wait()
+	return stderr.Bytes(), err
+}
+
+// ParseProgress parses ffmpeg -progress key=value output, calling fn at the end of every block.
+// Values ffmpeg reports as N/A keep their previous value
+func ParseProgress(r io.Reader, fn ProgressFunc) error {
+	var report ProgressReport
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "out_time_us", "out_time_ms": // both are microseconds
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				report.OutTime = float64(us) / 1e6
+			}
+		case "speed":
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				report.Speed = v
+			}
+		case "total_size":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				report.Size = v
+			}
+		case "progress":
+			report.End = value == "end"
+			fn(report)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,46 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	input := strings.Join([]string{
+		"frame=0",
+		"total_size=N/A",
+		"out_time_us=N/A",
+		"out_time_ms=N/A",
+		"speed=N/A",
+		"progress=continue",
+		"frame=240",
+		"total_size=1048576",
+		"out_time_us=10000000",
+		"out_time_ms=10000000",
+		"out_time=00:00:10.000000",
+		"speed=20.5x",
+		"progress=continue",
+		"total_size=2097152",
+		"out_time_us=21500000",
+		"speed=21x",
+		"progress=end",
+	}, "\n")
+
+	var reports []ProgressReport
+	if err := ParseProgress(strings.NewReader(input), func(r ProgressReport) { reports = append(reports, r) }); err != nil {
+		t.Fatal(err)
+	}
+	want := []ProgressReport{
+		{},
+		{OutTime: 10, Speed: 20.5, Size: 1048576},
+		{OutTime: 21.5, Speed: 21, Size: 2097152, End: true},
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(reports), len(want), reports)
+	}
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("report %d = %+v, want %+v", i, reports[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// TimeRef is a parsed skip range endpoint. It is either an absolute timestamp ("00:01:30.5"),
+// a chapter ("Opening"), or a chapter plus/minus an offset ("Opening+5s", "End-90s")
+type TimeRef struct {
+	Raw     string  // original expression, tried first as an exact chapter name
+	Chapter string  // anchor chapter, empty for absolute timestamps
+	Offset  float64 // seconds added to the anchor, or the absolute time
+}
+
+// ParseTimeRef parses a skip range endpoint expression
+func ParseTimeRef(expr string) (TimeRef, error) {
+	raw := strings.TrimSpace(expr)
+	if raw == "" {
+		return TimeRef{}, fmt.Errorf("empty time reference")
+	}
+	ref := TimeRef{Raw: raw}
+
+	if looksLikeTime(raw) {
+		sec, err := ParseTimestamp(raw)
+		if err != nil {
+			return ref, fmt.Errorf("invalid timestamp %q: %v", raw, err)
+		}
+		ref.Offset = sec
+		return ref, nil
+	}
+
+	// chapter with an offset: split at the last +/- that is followed by a number
+	if i := strings.LastIndexAny(raw, "+-"); i > 0 && i < len(raw)-1 && looksLikeTime(raw[i+1:]) {
+		sec, err := ParseTimestamp(raw[i+1:])
+		if err != nil {
+			return ref, fmt.Errorf("invalid offset in %q: %v", raw, err)
+		}
+		if raw[i] == '-' {
+			sec = -sec
+		}
+		ref.Chapter = strings.TrimSpace(raw[:i])
+		ref.Offset = sec
+		return ref, nil
+	}
+
+	ref.Chapter = raw
+	return ref, nil
+}
+
+// Resolve returns the time in seconds the reference points to, clamped to [0, End]
+func (r TimeRef) Resolve(ch models.Chapters) (float64, error) {
+	t := r.Offset
+	if v, ok := ch[r.Raw]; ok {
+		// an exact chapter name wins, so titles like "Part-1" still work
+		t = v
+	} else if r.Chapter != "" {
+		v, ok := ch[r.Chapter]
+		if !ok {
+			return 0, fmt.Errorf("chapter %q not found", r.Chapter)
+		}
+		t = v + r.Offset
+	}
+	if end, ok := ch["End"]; ok {
+		t = math.Min(t, end)
+	}
+	return math.Max(t, 0), nil
+}
+
+// ValidateSkipRanges checks the syntax of every skip range before any processing starts.
+// Chapter names can only be checked per episode, but absolute ranges must be ordered
+func ValidateSkipRanges(skips []models.SkipRange) error {
+	for i, skip := range skips {
+		start, err := ParseTimeRef(skip.Start)
+		if err != nil {
+			return fmt.Errorf("skip range %d start: %v", i+1, err)
+		}
+		end, err := ParseTimeRef(skip.End)
+		if err != nil {
+			return fmt.Errorf("skip range %d end: %v", i+1, err)
+		}
+		if start.Chapter == "" && end.Chapter == "" && end.Offset <= start.Offset {
+			return fmt.Errorf("skip range %d: end %q must be after start %q", i+1, skip.End, skip.Start)
+		}
+	}
+	return nil
+}
+
+// ResolveSkipRanges resolves skip ranges against an episode's chapters. Ranges that cannot be
+// resolved (missing chapter, empty after clamping) are left out and reported as errors
+func ResolveSkipRanges(ch models.Chapters, skips []models.SkipRange) ([]models.Segment, []error) {
+	var resolved []models.Segment
+	var errs []error
+	for i, skip := range skips {
+		seg, err := resolveSkipRange(ch, skip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("skip range %d (%s → %s): %v", i+1, skip.Start, skip.End, err))
+			continue
+		}
+		resolved = append(resolved, seg)
+	}
+	return resolved, errs
+}
+
+func resolveSkipRange(ch models.Chapters, skip models.SkipRange) (models.Segment, error) {
+	startRef, err := ParseTimeRef(skip.Start)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	endRef, err := ParseTimeRef(skip.End)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	s, err := startRef.Resolve(ch)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	e, err := endRef.Resolve(ch)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	if e <= s {
+		return models.Segment{}, fmt.Errorf("end %.3fs is not after start %.3fs", e, s)
+	}
+	return models.Segment{Start: s, End: e}, nil
+}
+
+// ParseTimestamp parses "HH:MM:SS.fff", "MM:SS", plain seconds ("90.5") or Go durations ("90s", "1m30s", "500ms")
+func ParseTimestamp(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("too many ':' separators")
+		}
+		total := 0.0
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v < 0 || (i < len(parts)-1 && v != math.Trunc(v)) {
+				return 0, fmt.Errorf("bad field %q", p)
+			}
+			total = total*60 + v
+		}
+		return total, nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		if v < 0 {
+			return 0, fmt.Errorf("negative time")
+		}
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM:SS.fff, seconds or a duration like 90s")
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative time")
+	}
+	return d.Seconds(), nil
+}
+
+// looksLikeTime reports whether s is meant as a time rather than a chapter name: it starts with a
+// digit or '.' and either contains ':' or only uses digits and duration units (so "1st Half" stays a chapter)
+func looksLikeTime(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" || !(s[0] >= '0' && s[0] <= '9' || s[0] == '.') {
+		return false
+	}
+	return strings.Contains(s, ":") ||
+		strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789.hmsuµ", r) }) < 0
+}
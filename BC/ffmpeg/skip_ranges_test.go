@@ -0,0 +1,102 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+var testChapters = models.Chapters{
+	"Opening":  0,
+	"Part A":   90,
+	"Part-1":   300,
+	"1st Half": 400,
+	"Ending":   1300,
+	"End":      1420,
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for in, want := range map[string]float64{
+		"00:01:30.5": 90.5,
+		"1:30":       90,
+		"90.5":       90.5,
+		"90s":        90,
+		"1m30s":      90,
+		"500ms":      0.5,
+	} {
+		got, err := ParseTimestamp(in)
+		if err != nil || got != want {
+			t.Errorf("ParseTimestamp(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"1:2:3:4", "00:1.5:00", "abc", "-5"} {
+		if _, err := ParseTimestamp(in); err == nil {
+			t.Errorf("ParseTimestamp(%q) expected error", in)
+		}
+	}
+}
+
+func TestResolveTimeRef(t *testing.T) {
+	for expr, want := range map[string]float64{
+		"00:01:30.5":   90.5,
+		"Part A":       90,
+		"Opening+5s":   5,
+		"Part A-00:30": 60,
+		"End-90s":      1330,
+		"End+10s":      1420, // clamped to the end
+		"Opening-5":    0,    // clamped to zero
+		"Part-1":       300,  // exact chapter name wins over "Part" minus 1s
+		"1st Half":     400,
+		"99:00:00":     1420,
+	} {
+		ref, err := ParseTimeRef(expr)
+		if err != nil {
+			t.Fatalf("ParseTimeRef(%q): %v", expr, err)
+		}
+		got, err := ref.Resolve(testChapters)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %v, %v; want %v", expr, got, err, want)
+		}
+	}
+
+	ref, _ := ParseTimeRef("Recap+5s")
+	if _, err := ref.Resolve(testChapters); err == nil || !strings.Contains(err.Error(), `chapter "Recap" not found`) {
+		t.Errorf("expected missing chapter error, got %v", err)
+	}
+}
+
+func TestValidateSkipRanges(t *testing.T) {
+	ok := []models.SkipRange{{Start: "Opening", End: "Part A"}, {Start: "00:20:00", End: "End"}, {Start: "End-90s", End: "End"}}
+	if err := ValidateSkipRanges(ok); err != nil {
+		t.Errorf("ValidateSkipRanges(valid) = %v", err)
+	}
+	for _, bad := range []models.SkipRange{
+		{Start: "", End: "Part A"},
+		{Start: "00:1x:00", End: "End"},
+		{Start: "Opening+5", End: "Opening+1:2:3:4"},
+		{Start: "00:05:00", End: "00:04:00"},
+	} {
+		if err := ValidateSkipRanges([]models.SkipRange{bad}); err == nil {
+			t.Errorf("ValidateSkipRanges(%+v) expected error", bad)
+		}
+	}
+}
+
+func TestComputeKeepSegments(t *testing.T) {
+	got := ComputeKeepSegments(testChapters, []models.SkipRange{
+		{Start: "Opening", End: "Part A"},
+		{Start: "End-120s", End: "End"},
+		{Start: "Recap", End: "Part A"}, // missing chapter: ignored
+	})
+	want := []models.Segment{{Start: 90, End: 1300}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ComputeKeepSegments() = %+v, want %+v", got, want)
+	}
+
+	_, errs := ResolveSkipRanges(testChapters, []models.SkipRange{{Start: "Recap", End: "Part A"}, {Start: "Ending", End: "Part A"}})
+	if len(errs) != 2 {
+		t.Errorf("ResolveSkipRanges errors = %v, want 2", errs)
+	}
+}
@@ -0,0 +1,249 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanke08/videoprocessor/utils"
+)
+
+// smartCutEpsilon is how close (in seconds) a cut must be to a keyframe to count as on it
+const smartCutEpsilon = 0.001
+
+// CutPiece is a piece of a smart-cut segment: either stream copied between two keyframes or re-encoded
+type CutPiece struct {
+	Start float64
+	End   float64
+	Copy  bool
+}
+
+// PlanSmartCut splits [start, end] at the first and last keyframe inside it: the head before the
+// first keyframe and the tail after the last one are re-encoded, the middle is stream copied.
+// Without two keyframes inside the range the whole segment is re-encoded
+func PlanSmartCut(start, end float64, keyframes []float64) []CutPiece {
+	first, last := -1.0, -1.0
+	for _, k := range keyframes {
+		if k < start-smartCutEpsilon || k > end+smartCutEpsilon {
+			continue
+		}
+		if first < 0 || k < first {
+			first = k
+		}
+		if k > last {
+			last = k
+		}
+	}
+	if first < 0 || last-first <= smartCutEpsilon {
+		return []CutPiece{{Start: start, End: end}}
+	}
+
+	var pieces []CutPiece
+	if first-start > smartCutEpsilon {
+		pieces = append(pieces, CutPiece{Start: start, End: first})
+	} else {
+		first = start
+	}
+	if end-last <= smartCutEpsilon {
+		last = end
+	}
+	pieces = append(pieces, CutPiece{Start: first, End: last, Copy: true})
+	if end-last > smartCutEpsilon {
+		pieces = append(pieces, CutPiece{Start: last, End: end})
+	}
+	return pieces
+}
+
+// Keyframes returns the timestamps of the video keyframes between from and to, read from the
+// packet flags so nothing is decoded
+func Keyframes(ctx context.Context, file string, from, to float64) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	out, err := RunCmd(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-read_intervals", fmt.Sprintf("%.3f%%%.3f", max(from-1, 0), to+1),
+		"-show_entries", "packet=pts_time,flags", "-of", "csv=p=0", file)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe keyframes failed: %v (%s)", err, OutputTail(out))
+	}
+	return parseKeyframes(string(out)), nil
+}
+
+// parseKeyframes reads "pts_time,flags" lines and keeps the packets flagged as keyframes
+func parseKeyframes(out string) []float64 {
+	var keyframes []float64
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "K") {
+			continue
+		}
+		if t, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			keyframes = append(keyframes, t)
+		}
+	}
+	return keyframes
+}
+
+// VideoStreamInfo describes the first video stream of a file
+type VideoStreamInfo struct {
+	Codec   string `json:"codec_name"`
+	Profile string `json:"profile"`
+	PixFmt  string `json:"pix_fmt"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Level   int    `json:"level"` // H.264 level times 10, HEVC level times 30
+}
+
+// ProbeVideoStream reads the codec parameters of the first video stream
+func ProbeVideoStream(ctx context.Context, file string) (VideoStreamInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := RunCmd(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,profile,pix_fmt,width,height,level", "-of", "json", file)
+	if err != nil {
+		return VideoStreamInfo{}, fmt.Errorf("ffprobe video stream failed: %v (%s)", err, OutputTail(out))
+	}
+	var data struct {
+		Streams []VideoStreamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return VideoStreamInfo{}, fmt.Errorf("json unmarshal failed: %v", err)
+	}
+	if len(data.Streams) == 0 {
+		return VideoStreamInfo{}, fmt.Errorf("no video stream in %s", filepath.Base(file))
+	}
+	return data.Streams[0], nil
+}
+
+// smartEncoderArgs returns encoder arguments for the re-encoded fragments, following the source's profile,
+// level and pixel format. The encoder's parameter sets differ from the source's, and the concat demuxer
+// only keeps the first file's, so they are repeated in-band at every keyframe
+func smartEncoderArgs(v VideoStreamInfo) ([]string, error) {
+	var args []string
+	switch v.Codec {
+	case "h264":
+		args = []string{"-c:v:0", "libx264", "-crf", "18", "-preset", "veryfast", "-x264-params", "repeat-headers=1"}
+		switch strings.ToLower(v.Profile) {
+		case "high", "main", "baseline":
+			args = append(args, "-profile:v:0", strings.ToLower(v.Profile))
+		case "constrained baseline":
+			args = append(args, "-profile:v:0", "baseline")
+		}
+		if v.Level > 0 {
+			args = append(args, "-level:v:0", fmt.Sprintf("%.1f", float64(v.Level)/10))
+		}
+	case "hevc":
+		args = []string{"-c:v:0", "libx265", "-crf", "20", "-preset", "fast", "-x265-params", "repeat-headers=1"}
+		if v.Level > 0 {
+			args = append(args, "-level:v:0", fmt.Sprintf("%.1f", float64(v.Level)/30))
+		}
+	default:
+		return nil, fmt.Errorf("smart cut does not support %q video", v.Codec)
+	}
+	if v.PixFmt != "" {
+		args = append(args, "-pix_fmt:v:0", v.PixFmt)
+	}
+	return args, nil
+}
+
+// inbandHeaderArgs returns the bitstream filter that writes a stream-copied H.264/HEVC stream's parameter
+// sets in front of every keyframe, so they survive being joined after fragments encoded with other ones
+func inbandHeaderArgs(codec string) []string {
+	switch codec {
+	case "h264":
+		return []string{"-bsf:v:0", "h264_mp4toannexb"}
+	case "hevc":
+		return []string{"-bsf:v:0", "hevc_mp4toannexb"}
+	}
+	return nil
+}
+
+// SmartCutSegment writes [start, end] of file to out with frame-accurate boundaries: the fragments
+// before the first and after the last keyframe are re-encoded, the GOPs in between are stream copied.
+// Audio and subtitles are always copied. Pieces are written to workDir
+func SmartCutSegment(ctx context.Context, file, workDir, out string, start, end float64, streams StreamPlan, onProgress ProgressFunc) error {
+	video, err := ProbeVideoStream(ctx, file)
+	if err != nil {
+		return err
+	}
+	encoder, err := smartEncoderArgs(video)
+	if err != nil {
+		return err
+	}
+	keyframes, err := Keyframes(ctx, file, start, end)
+	if err != nil {
+		return err
+	}
+
+	pieces := PlanSmartCut(start, end, keyframes)
+	files := make([]string, 0, len(pieces))
+	for i, piece := range pieces {
+		pieceFile := filepath.Join(workDir, fmt.Sprintf("smart_%d.mkv", i))
+		args := []string{
+			"-y",
+			"-ss", fmt.Sprintf("%.6f", piece.Start),
+			"-i", file,
+			"-t", fmt.Sprintf("%.6f", piece.End-piece.Start),
+		}
+		args = append(args, streams.SourceMapArgs()...)
+		args = append(args, "-ignore_unknown", "-c", "copy")
+		if piece.Copy {
+			args = append(args, inbandHeaderArgs(video.Codec)...)
+		} else {
+			args = append(args, encoder...)
+		}
+		args = append(args, "-avoid_negative_ts", "make_zero", "-map_chapters", "-1")
+		args = append(args, streams.Audio.DispositionArgs()...)
+
+		var report ProgressFunc
+		if onProgress != nil {
+			offset := piece.Start - start
+			report = func(r ProgressReport) {
+				r.OutTime = min(offset+r.OutTime, end-start)
+				onProgress(r)
+			}
+		}
+		pieceCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		outb, err := RunFFmpeg(pieceCtx, report, append(args, pieceFile)...)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("smart cut piece %.3f-%.3f failed: %v (%s)", piece.Start, piece.End, err, OutputTail(outb))
+		}
+		files = append(files, pieceFile)
+	}
+
+	if len(files) == 1 {
+		return os.Rename(files[0], out)
+	}
+
+	listFile := filepath.Join(workDir, "smart_list.txt")
+	var list strings.Builder
+	for _, f := range files {
+		abs, _ := filepath.Abs(f)
+		fmt.Fprintf(&list, "file '%s'\n", utils.EscapeForFFmpeg(abs))
+	}
+	if err := os.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	concatCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	args := []string{"-y", "-f", "concat", "-safe", "0", "-i", listFile}
+	args = append(args, CopyMapArgs()...)
+	args = append(args, "-ignore_unknown", "-c", "copy")
+	args = append(args, streams.Audio.DispositionArgs()...)
+	outb, err := RunCmd(concatCtx, "ffmpeg", append(args, out)...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("smart cut concat failed: %v (%s)", err, OutputTail(outb))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package ffmpeg
+
+import (
+	"context"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPlanSmartCut(t *testing.T) {
+	keyframes := []float64{0, 2, 4, 6, 8, 10}
+	tests := []struct {
+		name       string
+		start, end float64
+		want       []CutPiece
+	}{
+		{"cuts between keyframes", 3.3, 7.7, []CutPiece{
+			{Start: 3.3, End: 4},
+			{Start: 4, End: 6, Copy: true},
+			{Start: 6, End: 7.7},
+		}},
+		{"start on a keyframe", 2, 7.7, []CutPiece{
+			{Start: 2, End: 6, Copy: true},
+			{Start: 6, End: 7.7},
+		}},
+		{"both on keyframes", 2, 8, []CutPiece{
+			{Start: 2, End: 8, Copy: true},
+		}},
+		{"end on a keyframe within epsilon", 3.3, 8.0004, []CutPiece{
+			{Start: 3.3, End: 4},
+			{Start: 4, End: 8.0004, Copy: true},
+		}},
+		{"one keyframe inside", 3.3, 5.5, []CutPiece{
+			{Start: 3.3, End: 5.5},
+		}},
+		{"no keyframe inside", 4.5, 5.5, []CutPiece{
+			{Start: 4.5, End: 5.5},
+		}},
+	}
+	for _, tt := range tests {
+		if got := PlanSmartCut(tt.start, tt.end, keyframes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: PlanSmartCut(%v, %v) = %+v, want %+v", tt.name, tt.start, tt.end, got, tt.want)
+		}
+	}
+	if got := PlanSmartCut(1, 3, nil); !reflect.DeepEqual(got, []CutPiece{{Start: 1, End: 3}}) {
+		t.Errorf("without keyframes: %+v", got)
+	}
+}
+
+func TestParseKeyframes(t *testing.T) {
+	out := "0.000000,K__\n0.040000,___\n2.000000,K_\nN/A,K__\n\n4.000000,K__,side_data\n"
+	want := []float64{0, 2, 4}
+	if got := parseKeyframes(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeyframes = %v, want %v", got, want)
+	}
+}
+
+func TestSmartEncoderArgs(t *testing.T) {
+	args, err := smartEncoderArgs(VideoStreamInfo{Codec: "h264", Profile: "High", PixFmt: "yuv420p", Level: 41})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	for _, want := range []string{"-c:v:0 libx264", "-profile:v:0 high", "-pix_fmt:v:0 yuv420p", "-level:v:0 4.1", "repeat-headers=1"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args %q lack %q", joined, want)
+		}
+	}
+	args, _ = smartEncoderArgs(VideoStreamInfo{Codec: "hevc", Level: 123})
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "-level:v:0 4.1") || !strings.Contains(joined, "-x265-params repeat-headers=1") {
+		t.Errorf("hevc args %q lack the level or in-band headers", joined)
+	}
+	if got := inbandHeaderArgs("hevc"); !reflect.DeepEqual(got, []string{"-bsf:v:0", "hevc_mp4toannexb"}) {
+		t.Errorf("inbandHeaderArgs(hevc) = %q", got)
+	}
+	if _, err := smartEncoderArgs(VideoStreamInfo{Codec: "mpeg2video"}); err == nil {
+		t.Error("expected an error for an unsupported codec")
+	}
+}
+
+// requireFFmpeg skips tests that need ffmpeg/ffprobe with libx264
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil || !strings.Contains(string(out), "libx264") {
+		t.Skip("ffmpeg without libx264")
+	}
+}
+
+// makeTestClip generates a 10s 25fps H.264 High profile clip (CABAC, B-frames, several reference frames,
+// unlike the smart cut encoder's settings) with a keyframe every 2s and a sine audio track
+func makeTestClip(t *testing.T, dir string) string {
+	t.Helper()
+	clip := filepath.Join(dir, "clip.mkv")
+	out, err := exec.Command("ffmpeg", "-v", "error", "-y",
+		"-f", "lavfi", "-i", "testsrc2=size=320x240:rate=25",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-t", "10",
+		"-c:v", "libx264", "-preset", "medium", "-profile:v", "high", "-crf", "30", "-refs", "4", "-bf", "3", "-g", "50", "-keyint_min", "50", "-sc_threshold", "0", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", clip).CombinedOutput()
+	if err != nil {
+		t.Fatalf("generating clip failed: %v (%s)", err, out)
+	}
+	return clip
+}
+
+// requireDecodes fails the test when ffmpeg reports errors decoding file, e.g. after a join of
+// fragments whose parameter sets were lost
+func requireDecodes(t *testing.T, file string) {
+	t.Helper()
+	out, err := exec.Command("ffmpeg", "-v", "error", "-i", file, "-f", "null", "-").CombinedOutput()
+	if err != nil || len(strings.TrimSpace(string(out))) > 0 {
+		t.Errorf("decoding %s failed: %v (%s)", filepath.Base(file), err, out)
+	}
+}
+
+// countVideoFrames counts the video packets of file
+func countVideoFrames(t *testing.T, file string) int {
+	t.Helper()
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0", "-count_packets",
+		"-show_entries", "stream=nb_read_packets", "-of", "csv=p=0", file).Output()
+	if err != nil {
+		t.Fatalf("ffprobe failed: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatalf("bad packet count %q", out)
+	}
+	return n
+}
+
+func TestSmartCutSegmentIsFrameAccurate(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	clip := makeTestClip(t, dir)
+	ctx := context.Background()
+
+	keyframes, err := Keyframes(ctx, clip, 3.3, 7.7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []float64{4, 6} {
+		found := false
+		for _, k := range keyframes {
+			found = found || math.Abs(k-want) < 0.01
+		}
+		if !found {
+			t.Fatalf("keyframes %v lack %v", keyframes, want)
+		}
+	}
+
+	var lastReport float64
+	out := filepath.Join(dir, "cut.mkv")
+	streams := StreamPlan{Audio: AudioPlan{Tracks: []int{0}}}
+	if err := SmartCutSegment(ctx, clip, dir, out, 3.3, 7.7, streams, func(r ProgressReport) { lastReport = r.OutTime }); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4.4s at 25fps; a keyframe-snapped copy would start at 2s and carry 1.3s more
+	if frames := countVideoFrames(t, out); frames < 109 || frames > 111 {
+		t.Errorf("smart cut has %d frames, want 110±1", frames)
+	}
+	dur, err := GetDuration(ctx, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(dur-4.4) > 0.1 {
+		t.Errorf("smart cut duration = %.3fs, want 4.4s", dur)
+	}
+	if lastReport <= 0 || lastReport > 4.4+1e-9 {
+		t.Errorf("last progress report at %.3fs, want within (0, 4.4]", lastReport)
+	}
+	requireDecodes(t, out)
+}
+
+func TestTrimSegmentWithMetadataSmartMode(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	clip := makeTestClip(t, dir)
+
+	streams := StreamPlan{Audio: AudioPlan{Tracks: []int{0}}}
+	out, _, err := TrimSegmentWithMetadata(context.Background(), clip, dir, dir, 1.5, 5.5, streams, CutSettings{Mode: CutSmart}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames := countVideoFrames(t, out); frames < 99 || frames > 101 {
+		t.Errorf("trimmed segment has %d frames, want 100±1", frames)
+	}
+	requireDecodes(t, out)
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// StreamPlan is the resolved audio and subtitle selection for one source file
+type StreamPlan struct {
+	Audio     AudioPlan
+	Subtitles SubtitlePlan
+}
+
+// ValidateStreamSelection checks the audio and subtitle selection of a request before any file is probed
+func ValidateStreamSelection(opts models.TrimOptions) error {
+	if opts.AudioIndex < 0 {
+		return fmt.Errorf("audioIndex must not be negative")
+	}
+	if err := ValidateAudioSelection(opts.Audio); err != nil {
+		return fmt.Errorf("audio selection: %v", err)
+	}
+	if err := ValidateSubtitleSelection(opts.Subtitles); err != nil {
+		return fmt.Errorf("subtitle selection: %v", err)
+	}
+	return nil
+}
+
+// ResolveStreamPlan probes file and resolves the audio and subtitle selections in opts against it
+func ResolveStreamPlan(ctx context.Context, file string, opts models.TrimOptions) (StreamPlan, error) {
+	plan := StreamPlan{}
+	audioTracks, err := ScanAudioTracks(ctx, file)
+	if err != nil {
+		return plan, err
+	}
+	if plan.Audio, err = ResolveAudioPlan(audioTracks, opts.Audio, opts.AudioIndex); err != nil {
+		return plan, fmt.Errorf("audio selection failed: %v", err)
+	}
+
+	subTracks, err := ScanSubtitles(ctx, file)
+	if err != nil {
+		return plan, err
+	}
+	if plan.Subtitles, err = ResolveSubtitlePlan(subTracks, opts.Subtitles); err != nil {
+		return plan, fmt.Errorf("subtitle selection failed: %v", err)
+	}
+	return plan, nil
+}
+
+// SourceMapArgs maps video plus the planned audio and subtitle tracks from input 0
+func (p StreamPlan) SourceMapArgs() []string {
+	args := []string{"-map", "0:v?"}
+	args = append(args, p.Audio.SourceMapArgs()...)
+	return append(args, p.Subtitles.SourceMapArgs()...)
+}
+
+// CopyMapArgs maps every video, audio and subtitle stream of input 0, for inputs that were already trimmed to a plan
+func CopyMapArgs() []string {
+	return []string{"-map", "0:v?", "-map", "0:a?", "-map", "0:s?"}
+}
@@ -4,26 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/sanke08/videoprocessor/models"
 )
 
 // SubtitleTrack represents a subtitle stream
 type SubtitleTrack struct {
-	Index    int    `json:"index"`
+	Index    int    `json:"index"`    // absolute stream index
+	Position int    `json:"position"` // subtitle-relative index, as used by -map 0:s:N
 	Language string `json:"language"`
 	Title    string `json:"title"`
 	Codec    string `json:"codec_name"`
 }
 
 // ScanSubtitles scans all subtitle tracks in a video file
-func ScanSubtitles(file string) ([]SubtitleTrack, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func ScanSubtitles(ctx context.Context, file string) ([]SubtitleTrack, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "ffprobe",
@@ -31,6 +31,7 @@ func ScanSubtitles(file string) ([]SubtitleTrack, error) {
 		"-select_streams", "s",
 		"-show_entries", "stream=index,codec_name:stream_tags=language,title",
 		"-of", "json", file)
+	hideWindow(cmd)
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -53,9 +54,10 @@ func ScanSubtitles(file string) ([]SubtitleTrack, error) {
 	}
 
 	var tracks []SubtitleTrack
-	for _, s := range data.Streams {
+	for i, s := range data.Streams {
 		tracks = append(tracks, SubtitleTrack{
 			Index:    s.Index,
+			Position: i,
 			Language: s.Tags.Language,
 			Title:    s.Tags.Title,
 			Codec:    s.CodecName,
@@ -65,9 +67,63 @@ func ScanSubtitles(file string) ([]SubtitleTrack, error) {
 	return tracks, nil
 }
 
+// SubtitlePlan is a resolved subtitle selection for one source file
+type SubtitlePlan struct {
+	Tracks []int // subtitle-relative indexes in the source, in output order
+}
+
+// ValidateSubtitleSelection checks the parts of a subtitle selection that do not depend on the file:
+// a known mode and, in language mode, at least one language and none listed twice
+func ValidateSubtitleSelection(sel models.SubtitleSelection) error {
+	switch strings.ToLower(sel.Mode) {
+	case "", "none", "all":
+		return nil
+	case "language":
+		if len(sel.Languages) == 0 {
+			return fmt.Errorf("subtitle mode \"language\" needs at least one language")
+		}
+		return validateLanguages("subtitle", sel.Languages)
+	default:
+		return fmt.Errorf("unknown subtitle mode %q", sel.Mode)
+	}
+}
+
+// ResolveSubtitlePlan picks the subtitle tracks to keep according to sel
+func ResolveSubtitlePlan(tracks []SubtitleTrack, sel models.SubtitleSelection) (SubtitlePlan, error) {
+	plan := SubtitlePlan{}
+	if err := ValidateSubtitleSelection(sel); err != nil {
+		return plan, err
+	}
+	switch strings.ToLower(sel.Mode) {
+	case "all":
+		for _, t := range tracks {
+			plan.Tracks = append(plan.Tracks, t.Position)
+		}
+	case "language":
+		for _, lang := range sel.Languages {
+			for _, t := range tracks {
+				if strings.EqualFold(trackLanguage(t.Language), strings.TrimSpace(lang)) {
+					plan.Tracks = append(plan.Tracks, t.Position)
+				}
+			}
+		}
+	}
+	return plan, nil
+}
+
+// SourceMapArgs maps the planned subtitle tracks from input 0 of an ffmpeg command.
+// Text and bitmap codecs are both stream-copied, so their packets are shifted with the video
+func (p SubtitlePlan) SourceMapArgs() []string {
+	args := []string{}
+	for _, idx := range p.Tracks {
+		args = append(args, "-map", fmt.Sprintf("0:s:%d", idx))
+	}
+	return args
+}
+
 // ExtractSubtitle extracts a specific subtitle track to a file
-func ExtractSubtitle(inputFile string, trackIndex int, outputFile string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+func ExtractSubtitle(ctx context.Context, inputFile string, trackIndex int, outputFile string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
 
 	// Determine output format based on extension
@@ -95,199 +151,8 @@ func ExtractSubtitle(inputFile string, trackIndex int, outputFile string) error
 
 	out, err := RunCmd(ctx, "ffmpeg", args...)
 	if err != nil {
-		return fmt.Errorf("subtitle extraction failed: %v (%s)", err, string(out))
-	}
-
-	return nil
-}
-
-// AdjustSubtitleTiming adjusts subtitle timing by shifting timestamps
-func AdjustSubtitleTiming(inputSub, outputSub string, offsetSeconds float64) error {
-	// Read subtitle file
-	content, err := os.ReadFile(inputSub)
-	if err != nil {
-		return fmt.Errorf("failed to read subtitle: %v", err)
-	}
-
-	ext := strings.ToLower(filepath.Ext(inputSub))
-
-	if ext == ".srt" {
-		return adjustSRTTiming(string(content), outputSub, offsetSeconds)
-	} else if ext == ".ass" || ext == ".ssa" {
-		return adjustASSTiming(string(content), outputSub, offsetSeconds)
-	}
-
-	// For other formats, use ffmpeg to shift
-	return shiftSubtitleWithFFmpeg(inputSub, outputSub, offsetSeconds)
-}
-
-func adjustSRTTiming(content, outputFile string, offset float64) error {
-	lines := strings.Split(content, "\n")
-	var result []string
-
-	for _, line := range lines {
-		// Check if line contains timestamp (format: 00:00:00,000 --> 00:00:00,000)
-		if strings.Contains(line, " --> ") {
-			parts := strings.Split(line, " --> ")
-			if len(parts) == 2 {
-				start := shiftSRTTimestamp(strings.TrimSpace(parts[0]), offset)
-				end := shiftSRTTimestamp(strings.TrimSpace(parts[1]), offset)
-				line = fmt.Sprintf("%s --> %s", start, end)
-			}
-		}
-		result = append(result, line)
-	}
-
-	return os.WriteFile(outputFile, []byte(strings.Join(result, "\n")), 0644)
-}
-
-func shiftSRTTimestamp(timestamp string, offset float64) string {
-	// Parse SRT timestamp: HH:MM:SS,mmm
-	parts := strings.Split(timestamp, ",")
-	if len(parts) != 2 {
-		return timestamp
-	}
-
-	timeParts := strings.Split(parts[0], ":")
-	if len(timeParts) != 3 {
-		return timestamp
-	}
-
-	hours, _ := strconv.Atoi(timeParts[0])
-	minutes, _ := strconv.Atoi(timeParts[1])
-	seconds, _ := strconv.Atoi(timeParts[2])
-	millis, _ := strconv.Atoi(parts[1])
-
-	totalSeconds := float64(hours*3600+minutes*60+seconds) + float64(millis)/1000.0
-	totalSeconds += offset
-
-	if totalSeconds < 0 {
-		totalSeconds = 0
-	}
-
-	newHours := int(totalSeconds) / 3600
-	newMinutes := (int(totalSeconds) % 3600) / 60
-	newSeconds := int(totalSeconds) % 60
-	newMillis := int((totalSeconds - float64(int(totalSeconds))) * 1000)
-
-	return fmt.Sprintf("%02d:%02d:%02d,%03d", newHours, newMinutes, newSeconds, newMillis)
-}
-
-func adjustASSTiming(content, outputFile string, offset float64) error {
-	lines := strings.Split(content, "\n")
-	var result []string
-
-	for _, line := range lines {
-		// ASS format: Dialogue: layer,start,end,style,name,marginL,marginR,marginV,effect,text
-		if strings.HasPrefix(line, "Dialogue:") {
-			parts := strings.SplitN(line, ",", 10)
-			if len(parts) >= 3 {
-				parts[1] = shiftASSTimestamp(strings.TrimSpace(parts[1]), offset)
-				parts[2] = shiftASSTimestamp(strings.TrimSpace(parts[2]), offset)
-				line = strings.Join(parts, ",")
-			}
-		}
-		result = append(result, line)
-	}
-
-	return os.WriteFile(outputFile, []byte(strings.Join(result, "\n")), 0644)
-}
-
-func shiftASSTimestamp(timestamp string, offset float64) string {
-	// Parse ASS timestamp: H:MM:SS.cc
-	parts := strings.Split(timestamp, ":")
-	if len(parts) != 3 {
-		return timestamp
-	}
-
-	hours, _ := strconv.Atoi(parts[0])
-	minutes, _ := strconv.Atoi(parts[1])
-	secParts := strings.Split(parts[2], ".")
-	seconds, _ := strconv.Atoi(secParts[0])
-	centisec := 0
-	if len(secParts) > 1 {
-		centisec, _ = strconv.Atoi(secParts[1])
-	}
-
-	totalSeconds := float64(hours*3600+minutes*60+seconds) + float64(centisec)/100.0
-	totalSeconds += offset
-
-	if totalSeconds < 0 {
-		totalSeconds = 0
-	}
-
-	newHours := int(totalSeconds) / 3600
-	newMinutes := (int(totalSeconds) % 3600) / 60
-	newSeconds := int(totalSeconds) % 60
-	newCentisec := int((totalSeconds - float64(int(totalSeconds))) * 100)
-
-	return fmt.Sprintf("%d:%02d:%02d.%02d", newHours, newMinutes, newSeconds, newCentisec)
-}
-
-func shiftSubtitleWithFFmpeg(inputSub, outputSub string, offset float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
-
-	offsetStr := fmt.Sprintf("%.3f", offset)
-
-	args := []string{
-		"-y",
-		"-itsoffset", offsetStr,
-		"-i", inputSub,
-		"-c", "copy",
-		outputSub,
-	}
-
-	out, err := RunCmd(ctx, "ffmpeg", args...)
-	if err != nil {
-		log.Printf("⚠️ FFmpeg subtitle shift failed, copying original: %v", err)
-		// Fallback: just copy the file
-		content, err2 := os.ReadFile(inputSub)
-		if err2 == nil {
-			return os.WriteFile(outputSub, content, 0644)
-		}
-		return fmt.Errorf("subtitle timing adjustment failed: %v (%s)", err, string(out))
+		return fmt.Errorf("subtitle extraction failed: %v (%s)", err, OutputTail(out))
 	}
 
 	return nil
 }
-
-// ExtractAllSubtitles extracts all subtitle tracks from a video file
-func ExtractAllSubtitles(videoFile, outputDir string) (map[int]string, error) {
-	tracks, err := ScanSubtitles(videoFile)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(tracks) == 0 {
-		log.Printf("No subtitle tracks found in %s", videoFile)
-		return make(map[int]string), nil
-	}
-
-	// Create subtitles subfolder
-	subsDir := filepath.Join(outputDir, "subtitles")
-	os.MkdirAll(subsDir, 0755)
-
-	extractedFiles := make(map[int]string)
-	baseName := strings.TrimSuffix(filepath.Base(videoFile), filepath.Ext(videoFile))
-
-	for _, track := range tracks {
-		lang := track.Language
-		if lang == "" {
-			lang = "unknown"
-		}
-
-		subFile := filepath.Join(subsDir, fmt.Sprintf("%s_%s_%d.srt", baseName, lang, track.Index))
-
-		err := ExtractSubtitle(videoFile, track.Index, subFile)
-		if err != nil {
-			log.Printf("⚠️ Failed to extract subtitle track %d: %v", track.Index, err)
-			continue
-		}
-
-		extractedFiles[track.Index] = subFile
-		log.Printf("✅ Extracted subtitle: %s (track %d, %s)", filepath.Base(subFile), track.Index, lang)
-	}
-
-	return extractedFiles, nil
-}
@@ -0,0 +1,170 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SubtitleCue is a single timed subtitle event
+type SubtitleCue struct {
+	Start float64
+	End   float64
+	Text  string // SRT: the text lines; ASS: everything after the End field (Style,Name,...,Text)
+	Layer string // ASS only
+}
+
+// SubtitleDoc is a parsed text subtitle file
+type SubtitleDoc struct {
+	Format string // "srt" or "ass"
+	Header string // ASS only: script info, styles and the [Events] Format line
+	Cues   []SubtitleCue
+}
+
+// ReadSubtitleFile parses an .srt, .ass or .ssa file
+func ReadSubtitleFile(path string) (*SubtitleDoc, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subtitle: %v", err)
+	}
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".srt":
+		return &SubtitleDoc{Format: "srt", Cues: ParseSRT(text)}, nil
+	case ".ass", ".ssa":
+		header, cues := ParseASS(text)
+		return &SubtitleDoc{Format: "ass", Header: header, Cues: cues}, nil
+	}
+	return nil, fmt.Errorf("unsupported subtitle format: %s", path)
+}
+
+// WriteSubtitleFile writes doc in its own format
+func WriteSubtitleFile(path string, doc *SubtitleDoc) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if doc.Format == "ass" {
+		header := doc.Header
+		if !strings.HasSuffix(header, "\n") {
+			header += "\n"
+		}
+		w.WriteString(header)
+		for _, c := range doc.Cues {
+			fmt.Fprintf(w, "Dialogue: %s,%s,%s,%s\n", c.Layer, FormatASSTime(c.Start), FormatASSTime(c.End), c.Text)
+		}
+	} else {
+		for i, c := range doc.Cues {
+			fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, FormatSRTTime(c.Start), FormatSRTTime(c.End), c.Text)
+		}
+	}
+	return w.Flush()
+}
+
+// ParseSRT parses SRT content into cues; malformed blocks are skipped
+func ParseSRT(content string) []SubtitleCue {
+	var cues []SubtitleCue
+	for _, block := range strings.Split(content, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			parts := strings.SplitN(line, "-->", 2)
+			start, err1 := ParseSubtitleTime(parts[0])
+			// the end field may be followed by position hints
+			endFields := strings.Fields(parts[1])
+			if err1 != nil || len(endFields) == 0 {
+				break
+			}
+			end, err2 := ParseSubtitleTime(endFields[0])
+			if err2 != nil {
+				break
+			}
+			cues = append(cues, SubtitleCue{Start: start, End: end, Text: strings.Join(lines[i+1:], "\n")})
+			break
+		}
+	}
+	return cues
+}
+
+// ParseASS splits ASS/SSA content into the header (up to and including the [Events] Format line)
+// and the Dialogue cues. Comment lines, other events and sections after [Events] are dropped
+func ParseASS(content string) (string, []SubtitleCue) {
+	var header strings.Builder
+	var cues []SubtitleCue
+	inEvents, seenEvents := false, false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			inEvents = strings.EqualFold(trimmed, "[Events]")
+			if inEvents {
+				seenEvents = true
+			}
+			if !seenEvents || inEvents {
+				header.WriteString(line + "\n")
+			}
+			continue
+		}
+		if !inEvents {
+			if !seenEvents {
+				header.WriteString(line + "\n")
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "Format:") {
+			header.WriteString(line + "\n")
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "Dialogue:") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(trimmed, "Dialogue:")), ",", 4)
+		if len(fields) < 4 {
+			continue
+		}
+		start, err1 := ParseSubtitleTime(fields[1])
+		end, err2 := ParseSubtitleTime(fields[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		cues = append(cues, SubtitleCue{Start: start, End: end, Layer: fields[0], Text: fields[3]})
+	}
+	return header.String(), cues
+}
+
+// ParseSubtitleTime parses SRT (HH:MM:SS,mmm) and ASS (H:MM:SS.cc) timestamps into seconds
+func ParseSubtitleTime(ts string) (float64, error) {
+	ts = strings.ReplaceAll(strings.TrimSpace(ts), ",", ".")
+	parts := strings.Split(ts, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	return float64(hours*3600+minutes*60) + seconds, nil
+}
+
+// FormatSRTTime formats seconds as HH:MM:SS,mmm
+func FormatSRTTime(sec float64) string {
+	ms := int64(math.Round(math.Max(sec, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
+
+// FormatASSTime formats seconds as H:MM:SS.cc
+func FormatASSTime(sec float64) string {
+	cs := int64(math.Round(math.Max(sec, 0) * 100))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, (cs/6000)%60, (cs/100)%60, cs%100)
+}
@@ -0,0 +1,69 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSRT(t *testing.T) {
+	content := "1\n00:00:01,500 --> 00:00:03,000\nHello\nworld\n\n2\n00:01:00,000 --> 00:01:02,250 X1:0\nSecond\n\nbroken block\n"
+	cues := ParseSRT(content)
+	if len(cues) != 2 {
+		t.Fatalf("got %d cues: %+v", len(cues), cues)
+	}
+	if cues[0].Start != 1.5 || cues[0].End != 3 || cues[0].Text != "Hello\nworld" {
+		t.Errorf("cue 0 = %+v", cues[0])
+	}
+	if cues[1].Start != 60 || cues[1].End != 62.25 || cues[1].Text != "Second" {
+		t.Errorf("cue 1 = %+v", cues[1])
+	}
+}
+
+func TestParseASS(t *testing.T) {
+	content := "[Script Info]\nTitle: x\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Comment: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,note\n" +
+		"Dialogue: 0,0:00:01.50,0:00:02.00,Default,,0,0,0,,Hi, there\n" +
+		"[Fonts]\nfontname: a.ttf\n"
+	header, cues := ParseASS(content)
+	if len(cues) != 1 || cues[0].Start != 1.5 || cues[0].End != 2 || cues[0].Text != "Default,,0,0,0,,Hi, there" {
+		t.Fatalf("cues = %+v", cues)
+	}
+	if want := "[Script Info]\nTitle: x\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+}
+
+func TestSubtitleTimeFormatting(t *testing.T) {
+	if got := FormatSRTTime(3723.4567); got != "01:02:03,457" {
+		t.Errorf("FormatSRTTime = %s", got)
+	}
+	if got := FormatASSTime(3723.456); got != "1:02:03.46" {
+		t.Errorf("FormatASSTime = %s", got)
+	}
+	if got := FormatSRTTime(-1); got != "00:00:00,000" {
+		t.Errorf("FormatSRTTime(-1) = %s", got)
+	}
+}
+
+func TestSubtitleFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.srt")
+	doc := &SubtitleDoc{Format: "srt", Cues: []SubtitleCue{{Start: 1, End: 2, Text: "one"}, {Start: 3.25, End: 4, Text: "two\nlines"}}}
+	if err := WriteSubtitleFile(path, doc); err != nil {
+		t.Fatal(err)
+	}
+	// CRLF and BOM are tolerated on read
+	content, _ := os.ReadFile(path)
+	crlf := "\ufeff" + strings.ReplaceAll(string(content), "\n", "\r\n")
+	if err := os.WriteFile(path, []byte(crlf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadSubtitleFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Cues) != 2 || got.Cues[1].Start != 3.25 || got.Cues[1].Text != "two\nlines" {
+		t.Fatalf("round trip = %+v", got.Cues)
+	}
+}
@@ -0,0 +1,79 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveSubtitlePlan(t *testing.T) {
+	tracks := []SubtitleTrack{
+		{Index: 3, Position: 0, Language: "eng", Codec: "ass"},
+		{Index: 4, Position: 1, Language: "jpn", Codec: "hdmv_pgs_subtitle"},
+		{Index: 5, Position: 2, Language: "eng", Codec: "subrip"},
+	}
+	tests := []struct {
+		sel  models.SubtitleSelection
+		want []int
+	}{
+		{models.SubtitleSelection{}, nil},
+		{models.SubtitleSelection{Mode: "none"}, nil},
+		{models.SubtitleSelection{Mode: "all"}, []int{0, 1, 2}},
+		{models.SubtitleSelection{Mode: "language", Languages: []string{"jpn", "eng"}}, []int{1, 0, 2}},
+		{models.SubtitleSelection{Mode: "language", Languages: []string{"fre"}}, nil},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSubtitlePlan(tracks, tt.sel)
+		if err != nil {
+			t.Fatalf("ResolveSubtitlePlan(%+v) error: %v", tt.sel, err)
+		}
+		if !reflect.DeepEqual(got.Tracks, tt.want) {
+			t.Errorf("ResolveSubtitlePlan(%+v) = %v, want %v", tt.sel, got.Tracks, tt.want)
+		}
+	}
+
+	for _, sel := range []models.SubtitleSelection{
+		{Mode: "bitmap"},
+		{Mode: "language"},
+		{Mode: "language", Languages: []string{"eng", "Eng"}},
+	} {
+		if _, err := ResolveSubtitlePlan(tracks, sel); err == nil {
+			t.Errorf("ResolveSubtitlePlan(%+v) expected error", sel)
+		}
+	}
+}
+
+func TestValidateStreamSelection(t *testing.T) {
+	valid := models.TrimOptions{
+		AudioIndex: 1,
+		Audio:      models.AudioSelection{Mode: "index", Indexes: []int{1, 0}},
+		Subtitles:  models.SubtitleSelection{Mode: "language", Languages: []string{"eng"}},
+	}
+	if err := ValidateStreamSelection(valid); err != nil {
+		t.Errorf("valid selection rejected: %v", err)
+	}
+	for _, opts := range []models.TrimOptions{
+		{AudioIndex: -1},
+		{Audio: models.AudioSelection{Mode: "loudest"}},
+		{Audio: models.AudioSelection{Mode: "index", Indexes: []int{-2}}},
+		{Audio: models.AudioSelection{Mode: "language"}},
+		{Subtitles: models.SubtitleSelection{Mode: "forced"}},
+		{Subtitles: models.SubtitleSelection{Mode: "language"}},
+	} {
+		if err := ValidateStreamSelection(opts); err == nil {
+			t.Errorf("ValidateStreamSelection(%+v) accepted an invalid selection", opts)
+		}
+	}
+}
+
+func TestStreamPlanSourceMapArgs(t *testing.T) {
+	plan := StreamPlan{
+		Audio:     AudioPlan{Tracks: []int{1}},
+		Subtitles: SubtitlePlan{Tracks: []int{1, 0}},
+	}
+	want := []string{"-map", "0:v?", "-map", "0:a:1", "-map", "0:s:1", "-map", "0:s:0"}
+	if got := plan.SourceMapArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceMapArgs() = %v, want %v", got, want)
+	}
+}
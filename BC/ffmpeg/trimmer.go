@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,14 +14,17 @@ import (
 	"github.com/sanke08/videoprocessor/utils"
 )
 
-// TrimSegmentWithMetadata trims a video segment while preserving all streams and metadata
-// Returns the final trimmed file path and the shifted metadata path
-func TrimSegmentWithMetadata(file string, outputDir string, start, end float64) (string, string, error) {
+// TrimSegmentWithMetadata trims a video segment keeping video, the planned audio and subtitle tracks and metadata
+// Returns the final trimmed file path and the shifted metadata path, both in outputDir; scratch files go to workDir. cut selects stream copy, smart cut or re-encoding.
+// onProgress (may be nil) gets the trim's progress with OutTime relative to start. Cancelling ctx kills ffmpeg
+// and removes any partial output
+func TrimSegmentWithMetadata(ctx context.Context, file string, outputDir, workDir string, start, end float64, streams StreamPlan, cut CutSettings, onProgress ProgressFunc) (string, string, error) {
 	// prepare filenames
-	tempDir, err := os.MkdirTemp(outputDir, "tmp_trim_*")
+	tempDir, err := os.MkdirTemp(workDir, "tmp_trim_*")
 	if err != nil {
 		return "", "", fmt.Errorf("failed create temp dir: %v", err)
 	}
+	defer os.RemoveAll(tempDir)
 	// paths
 	origMeta := filepath.Join(tempDir, "orig_meta.txt")
 	shiftedMeta := filepath.Join(outputDir, fmt.Sprintf("%s_meta_%d.txt", strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), time.Now().UnixNano()))
@@ -28,7 +32,10 @@ func TrimSegmentWithMetadata(file string, outputDir string, start, end float64)
 	finalOut := utils.MakeTrimFilename(outputDir, file, start, end)
 
 	// 1. extract metadata from original
-	if err := ExtractMetadata(file, origMeta); err != nil {
+	if err := ExtractMetadata(ctx, file, origMeta); err != nil {
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
 		// if metadata extraction fails, continue but we won't be able to apply chapters
 		log.Printf("⚠️ metadata extract failed for %s: %v", file, err)
 		// still proceed but without metadata
@@ -46,96 +53,146 @@ func TrimSegmentWithMetadata(file string, outputDir string, start, end float64)
 	}
 
 	// 3. trim without copying chapters (we will reapply them)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if cut.Mode == CutReencode {
+		if err := EncodeSegment(ctx, file, tempTrim, start, end, streams, cut.Preset, onProgress); err != nil {
+			_ = os.Remove(tempTrim)
+			if shiftedMeta != "" {
+				_ = os.Remove(shiftedMeta)
+			}
+			return "", "", err
+		}
+		return finishTrim(ctx, tempTrim, origMeta, shiftedMeta, finalOut, streams)
+	}
+	if cut.Mode == CutSmart {
+		err := SmartCutSegment(ctx, file, tempDir, tempTrim, start, end, streams, onProgress)
+		if err == nil {
+			return finishTrim(ctx, tempTrim, origMeta, shiftedMeta, finalOut, streams)
+		}
+		_ = os.Remove(tempTrim)
+		if ctx.Err() != nil {
+			if shiftedMeta != "" {
+				_ = os.Remove(shiftedMeta)
+			}
+			return "", "", ctx.Err()
+		}
+		log.Printf("⚠️ smart cut failed for %s, falling back to stream copy: %v", filepath.Base(file), err)
+	}
+
+	trimCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 	args := []string{
 		"-y",
 		"-ss", fmt.Sprintf("%.3f", start), // Place -ss BEFORE -i for faster, accurate keyframe seeking
 		"-i", file,
 		"-to", fmt.Sprintf("%.3f", end),
-		"-map", "0:v?", // Map video streams
-		"-map", "0:a?", // Map audio streams
+	}
+	args = append(args, streams.SourceMapArgs()...) // Map video and the selected audio/subtitle streams
+	args = append(args,
 		"-ignore_unknown",
 		"-c", "copy",
 		"-copyts", // Copy timestamps to maintain accuracy
 		"-avoid_negative_ts", "make_zero",
 		"-map_chapters", "-1",
-		tempTrim,
+	)
+	args = append(args, streams.Audio.DispositionArgs()...)
+	args = append(args, tempTrim)
+	var report ProgressFunc
+	if onProgress != nil {
+		report = func(r ProgressReport) {
+			// -copyts keeps source timestamps, so out_time counts from start
+			r.OutTime = math.Min(math.Max(r.OutTime-start, 0), end-start)
+			onProgress(r)
+		}
 	}
-	out, err := RunCmd(ctx, "ffmpeg", args...)
+	out, err := RunFFmpeg(trimCtx, report, args...)
 	if err != nil {
 		// cleanup
 		_ = os.Remove(tempTrim)
-		return "", "", fmt.Errorf("ffmpeg trim failed: %v (%s)", err, string(out))
+		if shiftedMeta != "" {
+			_ = os.Remove(shiftedMeta)
+		}
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		return "", "", fmt.Errorf("ffmpeg trim failed: %v (%s)", err, OutputTail(out))
 	}
+	return finishTrim(ctx, tempTrim, origMeta, shiftedMeta, finalOut, streams)
+}
 
+// finishTrim reapplies the shifted chapters to the trimmed file and moves it to finalOut. The file is
+// completed next to tempTrim first, so finalOut never holds a partly written trim
+func finishTrim(ctx context.Context, tempTrim, origMeta, shiftedMeta, finalOut string, streams StreamPlan) (string, string, error) {
+	_ = os.Remove(origMeta)
+	done := tempTrim
 	// 4. reapply metadata if shiftedMeta exists
 	if shiftedMeta != "" {
-		ctx2, cancel2 := context.WithTimeout(context.Background(), 2*time.Minute)
+		ctx2, cancel2 := context.WithTimeout(ctx, 2*time.Minute)
 		defer cancel2()
-		// ffmpeg -y -i tempTrim -i shiftedMeta -map 0:v? -map 0:a? -map_metadata 1 -c copy finalOut
-		out2, err2 := RunCmd(ctx2, "ffmpeg", "-y", "-i", tempTrim, "-i", shiftedMeta, "-map", "0:v?", "-map", "0:a?", "-ignore_unknown", "-map_metadata", "1", "-c", "copy", finalOut)
+		withMeta := strings.TrimSuffix(tempTrim, filepath.Ext(tempTrim)) + "_chapters" + filepath.Ext(tempTrim)
+		// ffmpeg -y -i tempTrim -i shiftedMeta -map 0:v? -map 0:a? -map 0:s? -map_metadata 1 -c copy withMeta
+		args2 := []string{"-y", "-i", tempTrim, "-i", shiftedMeta}
+		args2 = append(args2, CopyMapArgs()...)
+		args2 = append(args2, "-ignore_unknown", "-map_metadata", "1", "-c", "copy")
+		args2 = append(args2, streams.Audio.DispositionArgs()...)
+		out2, err2 := RunCmd(ctx2, "ffmpeg", append(args2, withMeta)...)
+		if err2 != nil && ctx.Err() != nil {
+			_ = os.Remove(withMeta)
+			_ = os.Remove(tempTrim)
+			_ = os.Remove(shiftedMeta)
+			return "", "", ctx.Err()
+		}
 		if err2 != nil {
-			// fallback: rename tempTrim to finalOut
-			_ = os.Rename(tempTrim, finalOut)
-			log.Printf("⚠️ ffmpeg reapply metadata failed: %v (%s). Using trimmed file without metadata.", err2, string(out2))
-			// return finalOut and shiftedMeta (maybe partially useful)
+			// fallback: use tempTrim without metadata, shiftedMeta is still returned (maybe partially useful)
+			_ = os.Remove(withMeta)
+			log.Printf("⚠️ ffmpeg reapply metadata failed: %v (%s). Using trimmed file without metadata.", err2, OutputTail(out2))
+		} else {
 			_ = os.Remove(tempTrim)
-			// cleanup origMeta
-			_ = os.Remove(origMeta)
-			return finalOut, shiftedMeta, nil
+			done = withMeta
 		}
-		// success -> remove tempTrim
-		_ = os.Remove(tempTrim)
-		_ = os.Remove(origMeta)
-		return finalOut, shiftedMeta, nil
 	}
 
-	// no metadata to apply -> move tempTrim to finalOut
-	if err := os.Rename(tempTrim, finalOut); err != nil {
-		// fallback to copy
-		input, err2 := os.ReadFile(tempTrim)
-		if err2 == nil {
-			_ = os.WriteFile(finalOut, input, 0644)
-			_ = os.Remove(tempTrim)
-		} else {
-			return "", "", fmt.Errorf("failed to move trimmed file: %v", err)
-		}
+	if err := utils.MoveFile(done, finalOut); err != nil {
+		_ = os.Remove(done)
+		return "", "", fmt.Errorf("failed to move trimmed file: %v", err)
 	}
-	_ = os.Remove(origMeta)
-	return finalOut, "", nil
+	return finalOut, shiftedMeta, nil
 }
 
-// ComputeKeepSegments calculates which segments to keep based on skip ranges
-func ComputeKeepSegments(ch models.Chapters, skips []models.SkipRange) []struct{ Start, End float64 } {
-	end := ch["End"]
-	segments := []struct{ Start, End float64 }{{0, end}}
+// ComputeKeepSegments calculates which segments to keep based on skip ranges.
+// Ranges that cannot be resolved for this episode are logged and ignored
+func ComputeKeepSegments(ch models.Chapters, skips []models.SkipRange) []models.Segment {
+	ranges, errs := ResolveSkipRanges(ch, skips)
+	for _, err := range errs {
+		log.Printf("⚠️ Ignoring %v", err)
+	}
+	return KeepSegments(ch["End"], ranges)
+}
 
-	for _, skip := range skips {
-		s, okS := ch[skip.Start]
-		e, okE := ch[skip.End]
-		if !okS || !okE || e <= s {
-			continue
-		}
+// KeepSegments removes the resolved skip ranges from [0, end]
+func KeepSegments(end float64, ranges []models.Segment) []models.Segment {
+	segments := []models.Segment{{Start: 0, End: end}}
+
+	for _, skip := range ranges {
+		s, e := skip.Start, skip.End
 
-		newSegments := []struct{ Start, End float64 }{}
+		newSegments := []models.Segment{}
 		for _, seg := range segments {
 			if seg.End <= s || seg.Start >= e {
 				newSegments = append(newSegments, seg)
 				continue
 			}
 			if seg.Start < s {
-				newSegments = append(newSegments, struct{ Start, End float64 }{seg.Start, s})
+				newSegments = append(newSegments, models.Segment{Start: seg.Start, End: s})
 			}
 			if seg.End > e {
-				newSegments = append(newSegments, struct{ Start, End float64 }{e, seg.End})
+				newSegments = append(newSegments, models.Segment{Start: e, End: seg.End})
 			}
 		}
 		segments = newSegments
 	}
 
 	if len(segments) == 0 {
-		segments = append(segments, struct{ Start, End float64 }{0, end})
+		segments = append(segments, models.Segment{Start: 0, End: end})
 	}
 	return segments
 }
@@ -0,0 +1,85 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MediaInfo is what ffprobe reads from a written file
+type MediaInfo struct {
+	Duration float64
+	Streams  map[string]int // number of streams per codec type
+	Chapters int
+}
+
+// ProbeMedia reads the duration, stream types and chapter count of file
+func ProbeMedia(ctx context.Context, file string) (MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	out, err := RunCmd(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration:stream=codec_type",
+		"-show_chapters", "-of", "json", file)
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe failed: %v (%s)", err, OutputTail(out))
+	}
+	return parseMediaInfo(out)
+}
+
+// parseMediaInfo reads ffprobe's JSON output of ProbeMedia
+func parseMediaInfo(out []byte) (MediaInfo, error) {
+	var data struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Type string `json:"codec_type"`
+		} `json:"streams"`
+		Chapters []json.RawMessage `json:"chapters"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return MediaInfo{}, fmt.Errorf("json unmarshal failed: %v", err)
+	}
+	info := MediaInfo{Streams: make(map[string]int), Chapters: len(data.Chapters)}
+	info.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	for _, s := range data.Streams {
+		info.Streams[s.Type]++
+	}
+	return info, nil
+}
+
+// Check reports whether the file holds a stream of kind ("video", "audio" or "subtitle") and,
+// for video and audio, a positive duration
+func (m MediaInfo) Check(kind string) error {
+	if m.Streams[kind] == 0 {
+		return fmt.Errorf("no %s stream", kind)
+	}
+	if kind != "subtitle" && m.Duration <= 0 {
+		return fmt.Errorf("no duration")
+	}
+	return nil
+}
+
+// VerifyOutput probes a written file and checks it holds a readable stream of kind
+func VerifyOutput(ctx context.Context, file, kind string) (MediaInfo, error) {
+	info, err := ProbeMedia(ctx, file)
+	if err != nil {
+		return info, err
+	}
+	return info, info.Check(kind)
+}
+
+// DecodeCheck decodes the video and audio of file without writing anything and fails when ffmpeg
+// reports an error, which catches corrupt packets a stream copy carried over
+func DecodeCheck(ctx context.Context, file string, onProgress ProgressFunc) error {
+	out, err := RunFFmpeg(ctx, onProgress, "-v", "error", "-i", file, "-map", "0:v", "-map", "0:a?", "-f", "null", "-")
+	if err != nil {
+		return fmt.Errorf("decode failed: %v (%s)", err, OutputTail(out))
+	}
+	if len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("decode errors: %s", OutputTail(out))
+	}
+	return nil
+}
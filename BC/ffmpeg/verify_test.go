@@ -0,0 +1,29 @@
+package ffmpeg
+
+import "testing"
+
+func TestParseMediaInfo(t *testing.T) {
+	out := []byte(`{"chapters":[{"id":0},{"id":1}],"streams":[{"codec_type":"video"},{"codec_type":"audio"},{"codec_type":"audio"}],"format":{"duration":"1420.500000"}}`)
+	info, err := parseMediaInfo(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 1420.5 || info.Chapters != 2 || info.Streams["video"] != 1 || info.Streams["audio"] != 2 {
+		t.Errorf("parseMediaInfo = %+v", info)
+	}
+	if err := info.Check("video"); err != nil {
+		t.Errorf("Check(video) = %v", err)
+	}
+	if err := info.Check("subtitle"); err == nil {
+		t.Error("Check(subtitle) passed without a subtitle stream")
+	}
+
+	truncated, _ := parseMediaInfo([]byte(`{"streams":[{"codec_type":"video"}],"format":{"duration":"N/A"}}`))
+	if err := truncated.Check("video"); err == nil {
+		t.Error("Check passed a file without duration")
+	}
+	subs, _ := parseMediaInfo([]byte(`{"streams":[{"codec_type":"subtitle"}],"format":{}}`))
+	if err := subs.Check("subtitle"); err != nil {
+		t.Errorf("subtitle file without duration: %v", err)
+	}
+}
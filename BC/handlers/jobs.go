@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/services"
+)
+
+// ListJobsHandler handles the GET /api/jobs endpoint
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(services.Jobs.List())
+}
+
+// GetJobHandler handles the GET /api/jobs/{id} endpoint
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := services.Jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(job.Snapshot())
+}
+
+// CancelJobHandler handles the DELETE /api/jobs/{id} endpoint
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := services.Jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", 404)
+		return
+	}
+	if !job.Cancel() {
+		http.Error(w, "job already finished", 409)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.Snapshot())
+}
+
+// ResumeJobHandler handles the POST /api/jobs/{id}/resume endpoint: it restarts an interrupted job
+// as a new job with the same input, output and options
+func ResumeJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := services.Jobs.Resume(r.PathValue("id"))
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		http.Error(w, err.Error(), 404)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	go services.ProcessEpisodes(job)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started", "id": job.ID()})
+}
+
+// sseKeepAlive is how often an idle event stream gets a comment so proxies keep it open
+const sseKeepAlive = 15 * time.Second
+
+// EventsHandler handles the GET /api/jobs/{id}/events endpoint. It streams the job's events as
+// Server-Sent Events, replaying the history after Last-Event-ID (or ?after=) first
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := services.Jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", 404)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", 500)
+		return
+	}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("after")
+	}
+	after, _ := strconv.Atoi(last)
+
+	replay, events, unsubscribe := job.Subscribe(after)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, ev := range replay {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, ev)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes ev as one SSE message
+func writeEvent(w http.ResponseWriter, ev models.JobEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+}
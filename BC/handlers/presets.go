@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+)
+
+// PresetsHandler handles the GET /api/presets endpoint, listing the encoding presets of the reencode cut mode
+func PresetsHandler(w http.ResponseWriter, r *http.Request) {
+	presets := make([]ffmpeg.EncodePreset, 0, len(ffmpeg.Presets))
+	for _, name := range ffmpeg.PresetNames() {
+		presets = append(presets, ffmpeg.Presets[name])
+	}
+	json.NewEncoder(w).Encode(map[string]any{"default": ffmpeg.DefaultPreset, "presets": presets})
+}
@@ -4,22 +4,98 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/sanke08/videoprocessor/ffmpeg"
 	"github.com/sanke08/videoprocessor/models"
 	"github.com/sanke08/videoprocessor/services"
 )
 
+// processRequest is the body shared by /api/process and /api/plan
+type processRequest struct {
+	Input   string             `json:"input"`
+	Output  string             `json:"output"`
+	Options models.TrimOptions `json:"options"`
+}
+
+// decodeProcessRequest decodes and validates a process request, writing a 400 response on failure
+func decodeProcessRequest(w http.ResponseWriter, r *http.Request) (*processRequest, bool) {
+	var req processRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", 400)
+		return nil, false
+	}
+
+	if err := ffmpeg.ValidateSkipRanges(req.Options.SkipRanges); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if err := ffmpeg.ValidateStreamSelection(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := ffmpeg.ResolveCutSettings(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := ffmpeg.ResolveConcatPolicy(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := services.ResolvePartGrouping(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := services.ResolveNaming(req.Input, req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := services.ResolveVerify(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := services.ResolveDiskCheck(req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if _, err := services.ResolveWorkRoot(req.Output, req.Options); err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, false
+	}
+	if req.Options.Concurrency < 0 {
+		http.Error(w, "concurrency must not be negative", 400)
+		return nil, false
+	}
+	if req.Options.ExportAudio {
+		if _, err := ffmpeg.AudioExportExt(req.Options.AudioExportFormat); err != nil {
+			http.Error(w, err.Error(), 400)
+			return nil, false
+		}
+	}
+	return &req, true
+}
+
 // ProcessHandler handles the /api/process endpoint
 func ProcessHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Input   string             `json:"input"`
-		Output  string             `json:"output"`
-		Options models.TrimOptions `json:"options"`
+	req, ok := decodeProcessRequest(w, r)
+	if !ok {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON body", 400)
+
+	job := services.Jobs.Create(req.Input, req.Output, req.Options)
+	go services.ProcessEpisodes(job)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started", "id": job.ID()})
+}
+
+// PlanHandler handles the /api/plan endpoint: a dry run of /api/process that changes nothing on disk
+func PlanHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeProcessRequest(w, r)
+	if !ok {
 		return
 	}
 
-	go services.ProcessEpisodes(req.Input, req.Output, req.Options)
-	w.Write([]byte(`{"status":"started"}`))
+	plan, err := services.PlanEpisodes(r.Context(), req.Input, req.Options)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(plan)
 }
@@ -10,7 +10,7 @@ import (
 // ScanHandler handles the /api/scan endpoint
 func ScanHandler(w http.ResponseWriter, r *http.Request) {
 	folder := r.URL.Query().Get("path")
-	result, err := ffmpeg.ScanFirstTwoEpisodes(folder)
+	result, err := ffmpeg.ScanFirstTwoEpisodes(r.Context(), folder)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
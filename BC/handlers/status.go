@@ -5,13 +5,14 @@ import (
 	"net/http"
 
 	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/services"
 )
 
-// StatusHandler handles the /api/status endpoint
+// StatusHandler handles the /api/status endpoint, reporting the most recent job
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
-	var snapshot models.Progress
-	models.ProgressState.Get(func(p *models.Progress) {
-		snapshot = *p
-	})
+	snapshot := models.Progress{Status: "idle"}
+	if job, ok := services.Jobs.Latest(); ok {
+		snapshot = job.Progress
+	}
 	json.NewEncoder(w).Encode(snapshot)
 }
@@ -1,20 +1,55 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"path/filepath"
 
 	"github.com/sanke08/videoprocessor/handlers"
 	"github.com/sanke08/videoprocessor/middleware"
+	"github.com/sanke08/videoprocessor/services"
 )
 
 func main() {
+	workers := flag.Int("workers", services.DefaultWorkers(), "episodes processed at once across all jobs")
+	dataDir := flag.String("data", "data", "directory where job records are kept")
+	workDir := flag.String("work", "", "directory for the intermediate files of every job (default: each job's output folder)")
+	flag.Parse()
+	services.Pool = services.NewWorkerPool(*workers)
+	if *workDir != "" {
+		abs, err := filepath.Abs(*workDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		services.WorkRoot = abs
+	}
+
+	store, err := services.OpenJobStore(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := services.Jobs.UseStore(store); err != nil {
+		log.Fatalf("failed to load jobs from %s: %v", *dataDir, err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/scan", handlers.ScanHandler)
 	mux.HandleFunc("/api/process", handlers.ProcessHandler)
+	mux.HandleFunc("POST /api/plan", handlers.PlanHandler)
+	mux.HandleFunc("GET /api/presets", handlers.PresetsHandler)
 	mux.HandleFunc("/api/status", handlers.StatusHandler)
+	mux.HandleFunc("GET /api/jobs", handlers.ListJobsHandler)
+	mux.HandleFunc("GET /api/jobs/{id}", handlers.GetJobHandler)
+	mux.HandleFunc("GET /api/jobs/{id}/events", handlers.EventsHandler)
+	mux.HandleFunc("DELETE /api/jobs/{id}", handlers.CancelJobHandler)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", handlers.CancelJobHandler)
+	mux.HandleFunc("POST /api/jobs/{id}/resume", handlers.ResumeJobHandler)
 
 	handler := middleware.EnableCORS(mux)
-	log.Println("🚀 Server running at http://localhost:8080")
+	log.Printf("🚀 Server running at http://localhost:8080 (%d worker(s))", services.Pool.Size())
+	if services.WorkRoot != "" {
+		log.Printf("🗂️ Intermediate files go to %s", services.WorkRoot)
+	}
 	log.Fatal(http.ListenAndServe(":8080", handler))
 }
@@ -1,6 +1,6 @@
 package models
 
-import "sync"
+import "time"
 
 // AudioTrack represents an audio stream track
 type AudioTrack struct {
@@ -12,11 +12,20 @@ type AudioTrack struct {
 // Chapters represents chapter markers with their timestamps
 type Chapters map[string]float64
 
+// SubtitleTrackInfo represents a subtitle stream of the scanned episode
+type SubtitleTrackInfo struct {
+	Index int    `json:"index"` // subtitle-relative index
+	Lang  string `json:"lang"`
+	Title string `json:"title"`
+	Codec string `json:"codec"`
+}
+
 // ScanResult contains the result of scanning video files
 type ScanResult struct {
-	Chapters    Chapters     `json:"chapters"`
-	AudioTracks []AudioTrack `json:"audioTracks"`
-	FirstFile   string       `json:"firstFile"`
+	Chapters       Chapters            `json:"chapters"`
+	AudioTracks    []AudioTrack        `json:"audioTracks"`
+	SubtitleTracks []SubtitleTrackInfo `json:"subtitleTracks"`
+	FirstFile      string              `json:"firstFile"`
 }
 
 // SkipRange defines a range to skip in the video
@@ -25,11 +34,83 @@ type SkipRange struct {
 	End   string `json:"end"`
 }
 
+// AudioSelection chooses which audio streams are kept in trimmed and merged files
+type AudioSelection struct {
+	Mode      string   `json:"mode"`                // "all" (default), "index" or "language"
+	Indexes   []int    `json:"indexes,omitempty"`   // audio-relative track indexes for "index" mode, in output order
+	Languages []string `json:"languages,omitempty"` // language codes (e.g. "jpn") for "language" mode, in output order
+}
+
+// SubtitleSelection chooses which subtitle streams (text or bitmap) are carried into the output
+type SubtitleSelection struct {
+	Mode      string   `json:"mode"`                // "none" (default), "all" or "language"
+	Languages []string `json:"languages,omitempty"` // language codes for "language" mode, in output order
+}
+
+// Segment is a time range in seconds
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
 // TrimOptions contains options for trimming operations
 type TrimOptions struct {
-	SkipRanges []SkipRange `json:"skipRanges"`
-	Parts      int         `json:"parts"`
-	AudioIndex int         `json:"audioIndex"` // Default audio track (not used for removal, just for reference)
+	SkipRanges []SkipRange       `json:"skipRanges"`
+	Parts      int               `json:"parts"`
+	AudioIndex int               `json:"audioIndex"` // Preferred default audio track (audio-relative index)
+	Audio      AudioSelection    `json:"audio"`
+	Subtitles  SubtitleSelection `json:"subtitles"`
+	// ExportSubtitles writes the selected text subtitle tracks (all of them when Subtitles.Mode is "none")
+	// as retimed sidecar files subtitles/PartN_<lang>.srt|.ass next to each part
+	ExportSubtitles bool `json:"exportSubtitles"`
+	// ExportAudio writes every audio track of each part as audios/PartN_<lang>_<title>.<ext>
+	ExportAudio       bool   `json:"exportAudio"`
+	AudioExportFormat string `json:"audioExportFormat"` // "copy"/"mka" (default), "aac", "opus", "flac" or "mp3"
+	// CutMode is "copy" (default, cuts snap to keyframes), "smart" (frame-accurate cuts that
+	// re-encode only the GOP fragments at each boundary) or "reencode" (encode everything with Preset)
+	CutMode string `json:"cutMode,omitempty"`
+	Preset  string `json:"preset,omitempty"` // encoding preset for "reencode", e.g. "x265-balanced"
+	// Resume reuses trimmed episodes of earlier jobs with the same output folder when the source file
+	// and trim settings are unchanged and the file is intact
+	Resume bool `json:"resume,omitempty"`
+	// Concurrency caps how many of this job's episodes are processed at once; 0 uses the server-wide pool size
+	Concurrency int `json:"concurrency,omitempty"`
+	// ConcatPolicy decides what happens when the episodes of a part differ in resolution, codecs, audio
+	// layout or timebase: "refuse" (default, the part is not written), "reencode" (conform the outliers
+	// to the other episodes) or "filter" (join with the concat filter, re-encoding the whole part)
+	ConcatPolicy string `json:"concatPolicy,omitempty"`
+	// Grouping is how trimmed episodes are split into parts, always in episode order: "count" (default,
+	// Parts parts with the same number of episodes), "balanced" (Parts parts with even runtimes) or
+	// "duration" (parts as close to PartDuration each as possible)
+	Grouping     string        `json:"grouping,omitempty"`
+	PartDuration string        `json:"partDuration,omitempty"` // target part runtime for "duration", e.g. "2h" or "1:30:00"
+	Naming       NamingOptions `json:"naming"`
+	// KeepIntermediates leaves every intermediate file of the job in place, for debugging
+	KeepIntermediates bool `json:"keepIntermediates,omitempty"`
+	// WorkDir is an absolute folder for the job's segments, trimmed episodes and scratch files, overriding
+	// the server's -work folder; only finished parts and sidecars are moved to the output folder
+	WorkDir string `json:"workDir,omitempty"`
+	// Verify tunes the checks every merged part goes through
+	Verify VerifyOptions `json:"verify"`
+	// DiskCheck is what happens when the estimated space a job needs exceeds the free space:
+	// "refuse" (default, the job fails before trimming), "warn" (log and continue) or "off"
+	DiskCheck string `json:"diskCheck,omitempty"`
+}
+
+// VerifyOptions tune the checks run on every part after merging
+type VerifyOptions struct {
+	Tolerance string `json:"tolerance,omitempty"` // allowed duration difference, default "1s"
+	Decode    bool   `json:"decode,omitempty"`    // also decode every part fully to detect corruption (slow)
+}
+
+// NamingOptions are the file name templates of a job. Templates take {placeholders}, numbers can be
+// zero-padded with {part:02}
+type NamingOptions struct {
+	Part      string `json:"part,omitempty"`      // merged parts, default "Part{part}"; {series}, {season}, {part}, {first_ep}, {last_ep}
+	Episode   string `json:"episode,omitempty"`   // trimmed episodes, default "merged_{name}"; {series}, {season}, {ep}, {name}
+	Series    string `json:"series,omitempty"`    // {series}, default the input folder's name
+	Season    int    `json:"season,omitempty"`    // {season}, default the number of a "Season 2"/"S02" folder, else 1
+	Collision string `json:"collision,omitempty"` // when a part file exists: "suffix" (default), "overwrite" or "fail"
 }
 
 // Progress tracks the progress of video processing
@@ -39,24 +120,140 @@ type Progress struct {
 	Percent   float64 `json:"percent"`
 	Status    string  `json:"status"`
 	Done      bool    `json:"done"`
-	mu        sync.Mutex
+	ETA       float64 `json:"eta,omitempty"` // estimated seconds left in the current phase
+	Queued    int     `json:"queued"`        // episodes waiting for a worker
+	Running   int     `json:"running"`       // episodes being processed
+	// Episodes is the per-episode trim progress
+	Episodes []EpisodeProgress `json:"episodes,omitempty"`
+}
+
+// EpisodeProgress is the trim progress of a single episode
+type EpisodeProgress struct {
+	Episode int     `json:"episode"` // 1-based episode number
+	File    string  `json:"file"`
+	Percent float64 `json:"percent"`
+	Speed   float64 `json:"speed,omitempty"` // ffmpeg speed as a multiple of realtime
+	Size    int64   `json:"size,omitempty"`  // bytes written so far
+	ETA     float64 `json:"eta,omitempty"`   // estimated seconds left
 }
 
-// ProgressState is the global progress state
-var ProgressState = &Progress{Status: "idle"}
+// OutputFile is a final file written by a job
+type OutputFile struct {
+	Part int    `json:"part"`
+	Kind string `json:"kind"` // "video", "audio" or "subtitle"
+	Path string `json:"path"`
+}
+
+// JobEvent is a single entry of a job's event stream
+type JobEvent struct {
+	Seq     int       `json:"seq"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`              // "phase", "episode", "part", "log", "warning" or "done"
+	Phase   string    `json:"phase,omitempty"`   // job status for "phase" and "done" events
+	Episode int       `json:"episode,omitempty"` // 1-based episode number
+	Part    int       `json:"part,omitempty"`    // 1-based part number
+	File    string    `json:"file,omitempty"`
+	State   string    `json:"state,omitempty"` // "started", "finished" or "failed" for episode/part events; "overwriting" for a part replacing a file
+	Message string    `json:"message,omitempty"`
+}
+
+// EpisodeStatus is the result record of a single episode of a job
+type EpisodeStatus struct {
+	Episode      int       `json:"episode"` // 1-based episode number
+	Input        string    `json:"input"`
+	State        string    `json:"state"` // "queued", "processing", "succeeded", "failed", "cancelled" or "interrupted"
+	KeepSegments []Segment `json:"keepSegments,omitempty"`
+	Output       string    `json:"output,omitempty"`      // trimmed episode file fed into the merge
+	Metadata     string    `json:"metadata,omitempty"`    // ffmetadata with the trimmed episode's chapters
+	Duration     float64   `json:"duration,omitempty"`    // duration of the trimmed episode
+	Size         int64     `json:"size,omitempty"`        // size of the trimmed episode in bytes
+	Fingerprint  string    `json:"fingerprint,omitempty"` // hash of the source file and trim settings
+	Reused       bool      `json:"reused,omitempty"`      // the trimmed episode of an earlier job was reused
+	Error        string    `json:"error,omitempty"`       // includes the tail of ffmpeg's output
+}
+
+// PartStatus is the result record of a merged part
+type PartStatus struct {
+	Part       int      `json:"part"` // 1-based part number
+	Episodes   []string `json:"episodes"`
+	Mismatches []string `json:"mismatches,omitempty"` // stream parameters differing from the part's other episodes
+	Action     string   `json:"action,omitempty"`     // "copy", "reencode" or "filter"; "refused" when left out
+	Output     string   `json:"output,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	// Verification is what the part should contain and what ffprobe found in it after merging
+	Verification *PartVerification `json:"verification,omitempty"`
+}
+
+// PartVerification is the result of checking a merged part
+type PartVerification struct {
+	ExpectedStreams  map[string]int `json:"expectedStreams"` // stream count per type ("video", "audio", "subtitle")
+	Streams          map[string]int `json:"streams,omitempty"`
+	ExpectedDuration float64        `json:"expectedDuration"` // sum of the trimmed episode durations
+	Duration         float64        `json:"duration,omitempty"`
+	ExpectedChapters int            `json:"expectedChapters"`
+	Chapters         int            `json:"chapters"`
+	Decoded          bool           `json:"decoded,omitempty"` // a full decode ran without errors
+	Passed           bool           `json:"passed"`
+	Problems         []string       `json:"problems,omitempty"`
+}
+
+// Job describes a single processing run and its progress.
+// A finished job's Progress.Status is its outcome: "succeeded", "partial", "failed", "cancelled",
+// or "interrupted" when the server stopped while it was running
+type Job struct {
+	ID       string          `json:"id"`
+	Input    string          `json:"input"`
+	Output   string          `json:"output"`
+	Options  TrimOptions     `json:"options"`
+	Progress Progress        `json:"progress"`
+	Episodes []EpisodeStatus `json:"episodes"`
+	Outputs  []OutputFile    `json:"outputs"`
+	Parts    []PartStatus    `json:"parts,omitempty"`
+	// Intermediates are the files and folders the job created besides its outputs and has not removed yet
+	Intermediates []string       `json:"intermediates,omitempty"`
+	Error         string         `json:"error,omitempty"`       // job-level failure such as an unreadable input folder or a failed merge
+	Space         *SpaceEstimate `json:"space,omitempty"`       // disk space estimate of the preflight check
+	Resumable     bool           `json:"resumable,omitempty"`   // interrupted, partial or failed and not resumed yet
+	ResumedFrom   string         `json:"resumedFrom,omitempty"` // ID of the interrupted job this one resumes
+	CreatedAt     time.Time      `json:"createdAt"`
+	FinishedAt    *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// SpaceEstimate is the disk space a job is expected to need and what was free when it started, in bytes
+type SpaceEstimate struct {
+	Temp           int64 `json:"temp"`  // peak of trimmed segments, episodes and parts being written
+	Final          int64 `json:"final"` // merged parts
+	TempFree       int64 `json:"tempFree"`
+	OutputFree     int64 `json:"outputFree"`
+	SameFilesystem bool  `json:"sameFilesystem"` // temp and final files share the free space
+}
+
+// EpisodePlan is the dry-run result for a single episode
+type EpisodePlan struct {
+	File            string    `json:"file"`
+	Duration        float64   `json:"duration"`
+	KeepSegments    []Segment `json:"keepSegments"`
+	RemovedDuration float64   `json:"removedDuration"`
+	ResultDuration  float64   `json:"resultDuration"`
+	Warnings        []string  `json:"warnings,omitempty"` // e.g. skip ranges referencing a missing chapter
+	Error           string    `json:"error,omitempty"`    // the episode would fail and be left out of the merge
+}
 
-// Update updates the progress state safely
-func (p *Progress) Update(fn func(*Progress)) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	fn(p)
+// PartPlan is the dry-run result for a merged part
+type PartPlan struct {
+	Part     int      `json:"part"`
+	Name     string   `json:"name"` // file name the part would get, before collision handling
+	Episodes []string `json:"episodes"`
+	Duration float64  `json:"duration"`
 }
 
-// Get gets the current progress state safely
-func (p *Progress) Get(fn func(*Progress)) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	fn(p)
+// Plan is the dry-run result of a process request
+type Plan struct {
+	Episodes        []EpisodePlan `json:"episodes"`
+	Parts           []PartPlan    `json:"parts"`
+	RemovedDuration float64       `json:"removedDuration"`
+	ResultDuration  float64       `json:"resultDuration"`
+	Flagged         int           `json:"flagged"` // episodes with warnings or errors
 }
 
 // MetaChapter represents a single chapter parsed from ffmetadata
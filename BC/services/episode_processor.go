@@ -14,12 +14,15 @@ import (
 	"github.com/sanke08/videoprocessor/utils"
 )
 
-// ProcessSingleEpisode processes a single episode with trimming and metadata preservation
-func ProcessSingleEpisode(file string, output string, ch models.Chapters, opts models.TrimOptions) (string, string, float64, error) {
+// ProcessSingleEpisode processes a single episode with trimming and metadata preservation, keeping segmentsData
+// and cutting them as cut says. Segments are trimmed into the job's private ws.WorkDir; the trimmed episode is
+// written to ws.Episodes as name.mkv, with a " (2)" style suffix when that file exists, and its chapters as
+// name_meta.txt. Every file it leaves behind is recorded with ws.Track.
+// onProgress (may be nil) gets the trim progress with OutTime counted across all kept segments.
+// When ctx is cancelled, running ffmpeg processes are killed and the episode's intermediates removed
+func ProcessSingleEpisode(ctx context.Context, file string, ws Workspace, name string, segmentsData []models.Segment, streams ffmpeg.StreamPlan, cut ffmpeg.CutSettings, onProgress ffmpeg.ProgressFunc) (string, string, float64, error) {
 	log.Printf("📼 Processing: %s", filepath.Base(file))
 
-	// compute segments
-	segmentsData := ffmpeg.ComputeKeepSegments(ch, opts.SkipRanges)
 	if len(segmentsData) == 0 {
 		return "", "", 0, fmt.Errorf("no segments to keep for %s", file)
 	}
@@ -28,37 +31,67 @@ func ProcessSingleEpisode(file string, output string, ch models.Chapters, opts m
 	trimmedParts := []string{}
 	trimmedMetaFiles := []string{}
 	totalDur := 0.0
+	trimmed := 0.0 // kept seconds of the segments before the current one
+	var trimErr error
 
 	for i, seg := range segmentsData {
 		if seg.End <= seg.Start {
 			continue
 		}
-		// TrimSegmentWithMetadata already uses -map 0 which preserves ALL streams (video, audio, subs)
-		trimFile, metaFile, err := ffmpeg.TrimSegmentWithMetadata(file, output, seg.Start, seg.End)
+		var report ffmpeg.ProgressFunc
+		if onProgress != nil {
+			offset := trimmed
+			report = func(r ffmpeg.ProgressReport) {
+				r.OutTime += offset
+				onProgress(r)
+			}
+		}
+		trimmed += seg.End - seg.Start
+		// TrimSegmentWithMetadata keeps video and the planned audio/subtitle tracks
+		trimFile, metaFile, err := ffmpeg.TrimSegmentWithMetadata(ctx, file, ws.WorkDir, ws.WorkDir, seg.Start, seg.End, streams, cut, report)
+		if ctx.Err() != nil {
+			removeFiles(trimmedParts, trimmedMetaFiles)
+			return "", "", 0, ctx.Err()
+		}
 		if err != nil {
 			log.Printf("⚠️ Trim part %d failed for %s: %v", i, file, err)
+			trimErr = err
 			// continue to next segment
 			continue
 		}
+		ws.Track(trimFile, metaFile)
 		trimmedParts = append(trimmedParts, trimFile)
 		trimmedMetaFiles = append(trimmedMetaFiles, metaFile)
-		dur, _ := ffmpeg.GetDuration(trimFile)
+		dur, _ := ffmpeg.GetDuration(ctx, trimFile)
 		totalDur += dur
 	}
 
 	if len(trimmedParts) == 0 {
+		if trimErr != nil {
+			return "", "", 0, fmt.Errorf("no valid segments created for %s: %v", file, trimErr)
+		}
 		return "", "", 0, fmt.Errorf("no valid segments created for %s", file)
 	}
 
-	var finalFile string
+	// the template-named file is reserved first, so concurrently trimmed episodes never share it
+	finalFile, err := reserveFile(filepath.Join(ws.Episodes, name+".mkv"))
+	if err != nil {
+		removeFiles(trimmedParts, trimmedMetaFiles)
+		return "", "", 0, err
+	}
+	ws.Track(finalFile)
 	if len(trimmedParts) == 1 {
 		// single piece: its metadata file is trimmedMetaFiles[0] (may be empty)
-		finalFile = trimmedParts[0]
+		if err := utils.MoveFile(trimmedParts[0], finalFile); err != nil {
+			removeFiles([]string{finalFile})
+			return "", "", 0, err
+		}
 	} else {
 		// multiple pieces -> concat them into a single episode (preserve ALL streams)
-		listFile := filepath.Join(output, fmt.Sprintf("concat_list_%d.txt", time.Now().UnixNano()))
+		listFile := filepath.Join(ws.WorkDir, fmt.Sprintf("concat_list_%d.txt", time.Now().UnixNano()))
 		f, err := os.Create(listFile)
 		if err != nil {
+			removeFiles([]string{finalFile})
 			return "", "", 0, err
 		}
 		for _, p := range trimmedParts {
@@ -67,30 +100,56 @@ func ProcessSingleEpisode(file string, output string, ch models.Chapters, opts m
 		}
 		f.Close()
 
-		mergedEpisode := filepath.Join(output, fmt.Sprintf("merged_%s_%d.mkv", strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), time.Now().UnixNano()))
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		concatCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 		defer cancel()
-		// Use explicit mapping to copy only video and audio, NOT subtitles
-		outb, err := ffmpeg.RunCmd(ctx, "ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-map", "0:v?", "-map", "0:a?", "-ignore_unknown", "-c", "copy", mergedEpisode)
+		// segments already carry only the planned streams; concat shifts subtitle packets with the video
+		args := []string{"-y", "-f", "concat", "-safe", "0", "-i", listFile}
+		args = append(args, ffmpeg.CopyMapArgs()...)
+		args = append(args, "-ignore_unknown", "-c", "copy")
+		args = append(args, streams.Audio.DispositionArgs()...)
+		// joined in the work folder and moved over the reserved file once complete
+		joined := filepath.Join(ws.WorkDir, filepath.Base(finalFile))
+		outb, err := ffmpeg.RunCmd(concatCtx, "ffmpeg", append(args, joined)...)
 		os.Remove(listFile)
+		if ctx.Err() != nil {
+			removeFiles([]string{joined, finalFile}, trimmedParts, trimmedMetaFiles)
+			return "", "", 0, ctx.Err()
+		}
 		if err != nil {
-			return "", "", 0, fmt.Errorf("ffmpeg concat episode parts failed: %v (%s)", err, string(outb))
+			removeFiles([]string{joined, finalFile})
+			return "", "", 0, fmt.Errorf("ffmpeg concat episode parts failed: %v (%s)", err, ffmpeg.OutputTail(outb))
 		}
-
-		// clean up individual trimmedParts
-		for _, p := range trimmedParts {
-			// conservative remove
-			if strings.Contains(strings.ToLower(p), "tmp") || strings.Contains(strings.ToLower(p), "_seg_") {
-				_ = os.Remove(p)
-			}
+		if err := utils.MoveFile(joined, finalFile); err != nil {
+			removeFiles([]string{joined, finalFile})
+			return "", "", 0, err
 		}
-		finalFile = mergedEpisode
+
+		removeFiles(trimmedParts)
 	}
 
+	// the chapters are kept next to the trimmed episode, where resuming finds them after the
+	// job's work folder is gone; the reserved name makes the file unique across jobs
 	metaFile := ""
-	if len(trimmedMetaFiles) > 0 {
-		metaFile = trimmedMetaFiles[0]
+	if len(trimmedMetaFiles) > 0 && trimmedMetaFiles[0] != "" {
+		metaFile = strings.TrimSuffix(finalFile, filepath.Ext(finalFile)) + "_meta.txt"
+		if err := utils.MoveFile(trimmedMetaFiles[0], metaFile); err != nil {
+			log.Printf("⚠️ Failed to keep the chapters of %s: %v", filepath.Base(finalFile), err)
+			metaFile = ""
+		} else {
+			ws.Track(metaFile)
+		}
 	}
 
 	return finalFile, metaFile, totalDur, nil
 }
+
+// removeFiles removes every non-empty path in the given lists, ignoring errors
+func removeFiles(lists ...[]string) {
+	for _, list := range lists {
+		for _, p := range list {
+			if p != "" {
+				_ = os.Remove(p)
+			}
+		}
+	}
+}
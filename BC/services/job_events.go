@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// maxEventHistory bounds the events kept for replay to late subscribers
+const maxEventHistory = 1000
+
+// jobEvents is a job's event history and live subscribers; it is guarded by the job's mutex
+type jobEvents struct {
+	seq     int
+	history []models.JobEvent
+	subs    map[chan models.JobEvent]struct{}
+	closed  bool
+}
+
+// emit stamps ev and delivers it to every subscriber. A subscriber that is not keeping up misses
+// the event rather than stalling the job
+func (e *jobEvents) emit(ev models.JobEvent) {
+	if e.closed {
+		return
+	}
+	e.seq++
+	ev.Seq = e.seq
+	ev.Time = time.Now()
+	e.history = append(e.history, ev)
+	if len(e.history) > maxEventHistory {
+		e.history = e.history[len(e.history)-maxEventHistory:]
+	}
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// close ends every subscription once the job is finished
+func (e *jobEvents) close() {
+	if e.closed {
+		return
+	}
+	e.closed = true
+	for ch := range e.subs {
+		close(ch)
+	}
+	e.subs = nil
+}
+
+// Emit publishes an event on the job's stream
+func (j *Job) Emit(ev models.JobEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events.emit(ev)
+}
+
+// Logf writes a line to the server log and publishes it as a log event
+func (j *Job) Logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	j.Emit(models.JobEvent{Type: "log", Message: msg})
+}
+
+// Warnf writes a line to the server log and publishes it as a warning event, for problems the job
+// carries on despite
+func (j *Job) Warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	j.Emit(models.JobEvent{Type: "warning", Message: msg})
+}
+
+// Subscribe returns the events after afterSeq that are still in the history, and a channel with the
+// live events that follow. The channel is closed when the job finishes or unsubscribe is called
+func (j *Job) Subscribe(afterSeq int) ([]models.JobEvent, <-chan models.JobEvent, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var replay []models.JobEvent
+	for _, ev := range j.events.history {
+		if ev.Seq > afterSeq {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch := make(chan models.JobEvent, 64)
+	if j.events.closed {
+		close(ch)
+		return replay, ch, func() {}
+	}
+	if j.events.subs == nil {
+		j.events.subs = make(map[chan models.JobEvent]struct{})
+	}
+	j.events.subs[ch] = struct{}{}
+
+	unsubscribe := func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.events.subs[ch]; ok {
+			delete(j.events.subs, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, unsubscribe
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestJobEventsReplayAndClose(t *testing.T) {
+	job := NewJobRegistry().Create("in", "out", models.TrimOptions{})
+	job.Emit(models.JobEvent{Type: "log", Message: "one"})
+	job.Emit(models.JobEvent{Type: "log", Message: "two"})
+
+	replay, events, unsubscribe := job.Subscribe(1)
+	defer unsubscribe()
+	if len(replay) != 1 || replay[0].Message != "two" || replay[0].Seq != 2 {
+		t.Fatalf("replay after 1 = %+v, want only event 2", replay)
+	}
+
+	job.Emit(models.JobEvent{Type: "episode", Episode: 1, State: "started"})
+	if ev := <-events; ev.Seq != 3 || ev.Type != "episode" {
+		t.Fatalf("live event = %+v, want episode event 3", ev)
+	}
+
+	job.Finish(func(p *models.Progress) { p.Status = "done" })
+	var last models.JobEvent
+	for ev := range events {
+		last = ev
+	}
+	if last.Type != "done" || last.Phase != "done" {
+		t.Fatalf("last event = %+v, want done", last)
+	}
+
+	// subscribing to a finished job replays the history and returns a closed channel
+	replay, events, _ = job.Subscribe(0)
+	if _, ok := <-events; ok {
+		t.Fatal("channel of a finished job should be closed")
+	}
+	if len(replay) != last.Seq {
+		t.Fatalf("replay has %d events, want %d", len(replay), last.Seq)
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/utils"
+)
+
+// Job is a registered processing run; its state is only accessed through Update and Snapshot
+type Job struct {
+	mu     sync.Mutex
+	state  models.Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	events jobEvents
+
+	store         *JobStore // nil when jobs are not persisted
+	version       int       // bumped on every state change
+	persistQueued bool
+}
+
+// ID returns the job identifier
+func (j *Job) ID() string {
+	return j.state.ID
+}
+
+// Context returns the job's context, which is cancelled by Cancel
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Cancel requests cancellation of a running job; it returns false if the job already finished
+func (j *Job) Cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Progress.Done {
+		return false
+	}
+	j.cancel()
+	j.state.Progress.Status = "cancelling"
+	j.events.emit(models.JobEvent{Type: "phase", Phase: "cancelling"})
+	j.schedulePersist()
+	return true
+}
+
+// Update updates the job state safely; a status change is published as a phase event
+func (j *Job) Update(fn func(*models.Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	before := j.state.Progress.Status
+	fn(&j.state)
+	if status := j.state.Progress.Status; status != before {
+		j.events.emit(models.JobEvent{Type: "phase", Phase: status})
+	}
+	j.schedulePersist()
+}
+
+// Finish applies the final progress update, marks the job done, closes the event stream, releases
+// its context and writes the final state to the store
+func (j *Job) Finish(fn func(*models.Progress)) {
+	j.mu.Lock()
+	fn(&j.state.Progress)
+	j.state.Progress.Done = true
+	now := time.Now()
+	j.state.FinishedAt = &now
+	j.events.emit(models.JobEvent{Type: "done", Phase: j.state.Progress.Status})
+	j.events.close()
+	j.cancel()
+	j.version++
+	j.mu.Unlock()
+	j.persist()
+}
+
+// Snapshot returns a copy of the current job state
+func (j *Job) Snapshot() models.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshotLocked()
+}
+
+// snapshotLocked copies the job state; j.mu must be held
+func (j *Job) snapshotLocked() models.Job {
+	snapshot := j.state
+	snapshot.Outputs = append([]models.OutputFile(nil), j.state.Outputs...)
+	snapshot.Progress.Episodes = append([]models.EpisodeProgress(nil), j.state.Progress.Episodes...)
+	snapshot.Episodes = make([]models.EpisodeStatus, len(j.state.Episodes))
+	for i, ep := range j.state.Episodes {
+		ep.KeepSegments = append([]models.Segment(nil), ep.KeepSegments...)
+		snapshot.Episodes[i] = ep
+	}
+	snapshot.Intermediates = append([]string(nil), j.state.Intermediates...)
+	if j.state.Space != nil {
+		space := *j.state.Space
+		snapshot.Space = &space
+	}
+	snapshot.Parts = nil
+	for _, part := range j.state.Parts {
+		part.Episodes = append([]string(nil), part.Episodes...)
+		part.Mismatches = append([]string(nil), part.Mismatches...)
+		if v := part.Verification; v != nil {
+			copied := *v
+			copied.ExpectedStreams = maps.Clone(v.ExpectedStreams)
+			copied.Streams = maps.Clone(v.Streams)
+			copied.Problems = append([]string(nil), v.Problems...)
+			part.Verification = &copied
+		}
+		snapshot.Parts = append(snapshot.Parts, part)
+	}
+	return snapshot
+}
+
+// UpdateEpisode updates the result record of episode i (0-based)
+func (j *Job) UpdateEpisode(i int, fn func(*models.EpisodeStatus)) {
+	j.Update(func(state *models.Job) {
+		if i >= 0 && i < len(state.Episodes) {
+			fn(&state.Episodes[i])
+		}
+	})
+}
+
+// UpdatePart updates the result record of part (1-based), adding it when it is new
+func (j *Job) UpdatePart(part int, fn func(*models.PartStatus)) {
+	j.Update(func(state *models.Job) {
+		for i := range state.Parts {
+			if state.Parts[i].Part == part {
+				fn(&state.Parts[i])
+				return
+			}
+		}
+		state.Parts = append(state.Parts, models.PartStatus{Part: part})
+		fn(&state.Parts[len(state.Parts)-1])
+	})
+}
+
+// AddOutputs records final files written by the job
+func (j *Job) AddOutputs(files ...models.OutputFile) {
+	j.Update(func(state *models.Job) {
+		state.Outputs = append(state.Outputs, files...)
+	})
+}
+
+// JobRegistry keeps track of all processing jobs by ID
+type JobRegistry struct {
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	store *JobStore
+}
+
+// Jobs is the global job registry
+var Jobs = NewJobRegistry()
+
+// NewJobRegistry creates an empty job registry
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{jobs: make(map[string]*Job)}
+}
+
+// Create registers a new queued job for the given input/output and options
+func (r *JobRegistry) Create(input, output string, opts models.TrimOptions) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		state: models.Job{
+			ID:        newJobID(),
+			Input:     input,
+			Output:    output,
+			Options:   opts,
+			Progress:  models.Progress{Status: "queued"},
+			CreatedAt: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	r.mu.Lock()
+	r.jobs[job.ID()] = job
+	job.store = r.store
+	r.mu.Unlock()
+	job.persist()
+	return job
+}
+
+// UseStore persists jobs to store from now on and loads the jobs it holds. Jobs that were still
+// running when the server stopped are marked interrupted and resumable, and the intermediates in
+// their manifests are removed except the trimmed episodes of succeeded episodes, as are partial copies
+// of their outputs
+func (r *JobRegistry) UseStore(store *JobStore) error {
+	saved, err := store.Load()
+	if err != nil {
+		return err
+	}
+	var interrupted []*Job
+	r.mu.Lock()
+	r.store = store
+	for _, state := range saved {
+		wasRunning := !state.Progress.Done
+		if wasRunning {
+			markInterrupted(&state)
+			if !state.Options.KeepIntermediates {
+				state.Intermediates = removeIntermediates(state.Intermediates, resumeFiles(state.Episodes))
+			}
+			// half-copied outputs are never under a final name, but their temporary copies may be left
+			if state.Output != "" {
+				utils.RemovePartialFiles(state.Output, filepath.Join(state.Output, "audios"), filepath.Join(state.Output, "subtitles"))
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		job := &Job{state: state, ctx: ctx, cancel: cancel, store: store}
+		job.events.closed = true
+		r.jobs[state.ID] = job
+		if wasRunning {
+			job.version++
+			interrupted = append(interrupted, job)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, job := range interrupted {
+		job.persist()
+	}
+	return nil
+}
+
+// Resume starts a new job with the input, output and options of a resumable job, reusing its
+// finished episodes
+func (r *JobRegistry) Resume(id string) (*Job, error) {
+	old, ok := r.Get(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	var state models.Job
+	claimed := false
+	old.Update(func(j *models.Job) {
+		state = *j
+		claimed = j.Resumable
+		j.Resumable = false
+	})
+	if !claimed {
+		return nil, ErrNotResumable
+	}
+	opts := state.Options
+	opts.Resume = true
+	job := r.Create(state.Input, state.Output, opts)
+	job.Update(func(j *models.Job) {
+		j.ResumedFrom = id
+	})
+	return job, nil
+}
+
+// Get returns the job with the given ID
+func (r *JobRegistry) Get(id string) (*Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	job, ok := r.jobs[id]
+	return job, ok
+}
+
+// List returns snapshots of all jobs, oldest first
+func (r *JobRegistry) List() []models.Job {
+	r.mu.RLock()
+	list := make([]models.Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		list = append(list, job.Snapshot())
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Latest returns a snapshot of the most recently created job
+func (r *JobRegistry) Latest() (models.Job, bool) {
+	list := r.List()
+	if len(list) == 0 {
+		return models.Job{}, false
+	}
+	return list[len(list)-1], true
+}
+
+var (
+	// ErrJobNotFound is returned for an unknown job ID
+	ErrJobNotFound = errors.New("job not found")
+	// ErrNotResumable is returned when resuming a job that succeeded, was cancelled or was already resumed
+	ErrNotResumable = errors.New("job is not resumable")
+)
+
+// newJobID generates a short random hex identifier
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// persistDelay batches the frequent progress updates of a running job into one write
+const persistDelay = time.Second
+
+// JobStore persists jobs as one JSON file per job under <dataDir>/jobs
+type JobStore struct {
+	dir   string
+	mu    sync.Mutex
+	saved map[string]int // last version written per job, so a late write never replaces a newer one
+}
+
+// OpenJobStore opens (and creates) the store under dataDir
+func OpenJobStore(dataDir string) (*JobStore, error) {
+	dir := filepath.Join(dataDir, "jobs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job store: %v", err)
+	}
+	return &JobStore{dir: dir, saved: make(map[string]int)}, nil
+}
+
+// Save writes the job if version is newer than the last one written. The file is replaced
+// atomically so a crash never leaves a truncated record
+func (s *JobStore) Save(job models.Job, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version <= s.saved[job.ID] {
+		return nil
+	}
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, job.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, job.ID+".json"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.saved[job.ID] = version
+	return nil
+}
+
+// Load reads every stored job; unreadable records are logged and skipped. The loaded jobs
+// start over at version 0
+func (s *JobStore) Load() ([]models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []models.Job
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			log.Printf("⚠️ Skipping job record %s: %v", e.Name(), err)
+			continue
+		}
+		var job models.Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+			log.Printf("⚠️ Skipping job record %s: %v", e.Name(), err)
+			continue
+		}
+		delete(s.saved, job.ID)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// schedulePersist queues a write of the job's state; it must be called with j.mu held
+func (j *Job) schedulePersist() {
+	j.version++
+	if j.store == nil || j.persistQueued {
+		return
+	}
+	j.persistQueued = true
+	time.AfterFunc(persistDelay, j.persist)
+}
+
+// persist writes the job's current state to the store
+func (j *Job) persist() {
+	if j.store == nil {
+		return
+	}
+	j.mu.Lock()
+	j.persistQueued = false
+	snapshot := j.snapshotLocked()
+	version := j.version
+	j.mu.Unlock()
+	if err := j.store.Save(snapshot, version); err != nil {
+		log.Printf("⚠️ Failed to persist job %s: %v", snapshot.ID, err)
+	}
+}
+
+// markInterrupted turns the record of a job that was running when the server stopped into a
+// finished, resumable one
+func markInterrupted(job *models.Job) {
+	now := time.Now()
+	job.Progress.Status = "interrupted"
+	job.Progress.Done = true
+	job.Progress.Queued = 0
+	job.Progress.Running = 0
+	job.Progress.ETA = 0
+	job.FinishedAt = &now
+	job.Resumable = true
+	for i := range job.Episodes {
+		if ep := &job.Episodes[i]; ep.State == "queued" || ep.State == "processing" {
+			ep.State = "interrupted"
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestJobStoreRestoresInterruptedJobs(t *testing.T) {
+	dataDir, output := t.TempDir(), t.TempDir()
+	store, err := OpenJobStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := filepath.Join(output, "Episode 1_seg_0_1300.mkv")
+	halfWritten := filepath.Join(output, "Episode 2_seg_0_1310.mkv")
+	workDir := filepath.Join(output, "tmp_job_running")
+	partTmp := filepath.Join(workDir, "Part1_tmp.mkv")
+	// files the job did not create survive even when they look like intermediates
+	unrelated := []string{filepath.Join(output, "notes.txt"), filepath.Join(output, "merged_Movie.mkv"), filepath.Join(output, "tmp", "keep.mkv")}
+	for _, d := range []string{workDir, filepath.Join(output, "tmp")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range append([]string{kept, halfWritten, partTmp}, unrelated...) {
+		if err := os.WriteFile(f, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	running := models.Job{
+		ID:            "running",
+		Input:         "in",
+		Output:        output,
+		Progress:      models.Progress{Status: "processing", Running: 1},
+		Intermediates: []string{workDir, kept, halfWritten},
+		Episodes: []models.EpisodeStatus{
+			{Episode: 1, State: "succeeded", Output: kept},
+			{Episode: 2, State: "processing"},
+		},
+	}
+	finished := models.Job{ID: "finished", Output: output, Progress: models.Progress{Status: "succeeded", Done: true}}
+	for _, j := range []models.Job{running, finished} {
+		if err := store.Save(j, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an older version must not replace what is stored
+	stale := running
+	stale.Input = "stale"
+	if err := store.Save(stale, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewJobRegistry()
+	if err := registry.UseStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	job, ok := registry.Get("running")
+	if !ok {
+		t.Fatal("running job was not restored")
+	}
+	got := job.Snapshot()
+	if got.Input != "in" {
+		t.Errorf("input = %q, the stale save overwrote the record", got.Input)
+	}
+	if got.Progress.Status != "interrupted" || !got.Progress.Done || !got.Resumable {
+		t.Errorf("progress = %+v, resumable = %v; want a done, resumable interrupted job", got.Progress, got.Resumable)
+	}
+	if got.Episodes[0].State != "succeeded" || got.Episodes[1].State != "interrupted" {
+		t.Errorf("episode states = %q, %q", got.Episodes[0].State, got.Episodes[1].State)
+	}
+	if job, _ := registry.Get("finished"); job.Snapshot().Resumable {
+		t.Error("a finished job must not be resumable")
+	}
+
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("trimmed episode of a succeeded episode was removed: %v", err)
+	}
+	for _, f := range []string{halfWritten, partTmp} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", filepath.Base(f))
+		}
+	}
+	for _, f := range unrelated {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s was not created by the job but was removed: %v", f, err)
+		}
+	}
+	if len(got.Intermediates) != 1 || got.Intermediates[0] != kept {
+		t.Errorf("manifest after cleanup = %q, want only the kept episode", got.Intermediates)
+	}
+
+	// the interrupted state is written back, so a second restart sees it as is
+	reloaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range reloaded {
+		if j.ID == "running" && j.Progress.Status != "interrupted" {
+			t.Errorf("stored status = %q, want interrupted", j.Progress.Status)
+		}
+	}
+
+	resumed, err := registry.Resume("running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := resumed.Snapshot(); s.ResumedFrom != "running" || s.Input != "in" || s.Output != output {
+		t.Errorf("resumed job = %+v", s)
+	}
+	if _, err := registry.Resume("running"); err != ErrNotResumable {
+		t.Errorf("second resume = %v, want ErrNotResumable", err)
+	}
+	if _, err := registry.Resume("missing"); err != ErrJobNotFound {
+		t.Errorf("resume of unknown job = %v, want ErrJobNotFound", err)
+	}
+}
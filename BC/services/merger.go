@@ -3,9 +3,10 @@ package services
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,135 +15,327 @@ import (
 	"github.com/sanke08/videoprocessor/utils"
 )
 
-// MergeEpisodes merges processed episodes into final parts
-func MergeEpisodes(processedFiles []string, metaFiles []string, durations []float64, output string, parts int) error {
+// MergeEpisodes merges processed episodes into final parts, reporting progress on job.
+// subtitles holds each episode's retimed sidecar subtitle tracks (nil when not exporting).
+// grouping splits the episodes into parts, by count or by their durations.
+// streams describes the tracks every processed episode carries, so each part gets the same default audio track.
+// Parts and their sidecars are written to ws.Staging and moved to ws.Output once ffprobe reads them back,
+// everything else goes to ws.WorkDir. Cancelling ctx stops the merge and removes the part currently being written
+func MergeEpisodes(ctx context.Context, job *Job, processedFiles []string, metaFiles []string, durations []float64, subtitles [][]EpisodeSubtitle, ws Workspace, grouping PartGrouping, streams ffmpeg.StreamPlan) error {
 	// Filter empty
 	valid := make([]string, 0, len(processedFiles))
 	validMeta := []string{}
 	validDur := []float64{}
+	validSubs := [][]EpisodeSubtitle{}
 	for i, f := range processedFiles {
 		if strings.TrimSpace(f) == "" {
 			continue
 		}
 		if _, err := os.Stat(f); err != nil {
-			log.Printf("⚠️ Skipping missing file in merge list: %s", f)
+			job.Logf("⚠️ Skipping missing file in merge list: %s", f)
 			continue
 		}
 		valid = append(valid, f)
 		validMeta = append(validMeta, metaFiles[i])
 		validDur = append(validDur, durations[i])
+		validSubs = append(validSubs, subtitles[i])
 	}
 
 	if len(valid) == 0 {
 		return fmt.Errorf("no files to merge")
 	}
 
-	// sanitize parts
-	if parts <= 0 {
-		parts = 1
+	state := job.Snapshot()
+	opts := state.Options
+	naming, _ := ResolveNaming(state.Input, opts) // validated with the request
+	episodes := map[string]models.EpisodeStatus{} // trimmed file -> episode record
+	for _, ep := range state.Episodes {
+		if ep.Output != "" {
+			episodes[ep.Output] = ep
+		}
 	}
-	if parts > len(valid) {
-		parts = len(valid)
+	used := map[string]bool{}                     // part files written by this job
+	policy, _ := ffmpeg.ResolveConcatPolicy(opts) // validated with the request
+	preset := ffmpeg.Presets[ffmpeg.DefaultPreset]
+	if cut, _ := ffmpeg.ResolveCutSettings(opts); cut.Mode == ffmpeg.CutReencode {
+		preset = cut.Preset
 	}
 
-	partSize := (len(valid) + parts - 1) / parts
+	groups := grouping.Groups(validDur)
+	tracker := newPhaseTracker(job, nil, len(groups), false)
+	for i, group := range groups {
+		tracker.SetWork(i, sum(validDur[group[0]:group[1]]))
+	}
 
-	for i := 0; i < parts; i++ {
-		start := i * partSize
-		end := utils.Min((i+1)*partSize, len(valid))
+	var skipped []string
+	for i, group := range groups {
+		start, end := group[0], group[1]
 		partFiles := valid[start:end]
 		partMeta := validMeta[start:end]
 		partDur := validDur[start:end]
-		if len(partFiles) == 0 {
-			continue
+		partSubs := validSubs[start:end]
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		job.Emit(models.JobEvent{Type: "part", Part: i + 1, State: "started"})
+		skip := func(err error) {
+			job.Logf("❌ %v", err)
+			job.UpdatePart(i+1, func(p *models.PartStatus) {
+				p.Episodes = append([]string(nil), partFiles...)
+				p.Error = err.Error()
+			})
+			job.Emit(models.JobEvent{Type: "part", Part: i + 1, State: "failed", Message: err.Error()})
+			tracker.Finish(i, false)
+			skipped = append(skipped, strconv.Itoa(i+1))
 		}
 
-		// concat list
-		listFile := filepath.Join(output, fmt.Sprintf("merge_part_%d_%d.txt", i+1, time.Now().UnixNano()))
-		f, err := os.Create(listFile)
+		partFinal, err := partPath(ws.Output, naming, i+1, partFiles, episodes, used)
 		if err != nil {
-			return err
+			skip(fmt.Errorf("part %d: %v", i+1, err))
+			continue
 		}
-		for _, pf := range partFiles {
-			abs, _ := filepath.Abs(pf)
-			_, _ = f.WriteString(fmt.Sprintf("file '%s'\n", utils.EscapeForFFmpeg(abs)))
+		used[partFinal] = true
+		if _, err := os.Stat(partFinal); err == nil {
+			// only under CollisionOverwrite; the other policies never pick an existing file
+			msg := fmt.Sprintf("part %d replaces the existing %s (collision policy overwrite)", i+1, filepath.Base(partFinal))
+			job.Warnf("⚠️ %s", msg)
+			job.Emit(models.JobEvent{Type: "part", Part: i + 1, File: partFinal, State: "overwriting", Message: msg})
 		}
-		f.Close()
+		partName := strings.TrimSuffix(filepath.Base(partFinal), filepath.Ext(partFinal))
+
+		// compare stream parameters before joining, the concat demuxer silently breaks on mismatches
+		inputs, err := checkPartStreams(ctx, job, i+1, partFiles, ws.WorkDir, policy)
+		if ctx.Err() != nil {
+			removeFiles(inputs.Temp)
+			return ctx.Err()
+		}
+		if err != nil {
+			skip(err)
+			continue
+		}
+
+		tmpMerged := filepath.Join(ws.WorkDir, fmt.Sprintf("Part%d_tmp.mkv", i+1))
+		var args []string
+		var concatCtx context.Context
+		var cancel context.CancelFunc
+		listFile := ""
+		if inputs.Filter {
+			for _, st := range inputs.Ref {
+				if st.Type == "subtitle" {
+					job.Logf("⚠️ Part %d is joined with the concat filter, its subtitle tracks are dropped", i+1)
+					break
+				}
+			}
+			job.Logf("🔄 Re-encoding part %d with the concat filter (%s)...", i+1, preset.Name)
+			// everything is re-encoded, so allow for long parts
+			concatCtx, cancel = context.WithTimeout(ctx, 3*time.Hour)
+			args = ffmpeg.ConcatFilterArgs(inputs.Files, inputs.Ref, preset)
+		} else {
+			// concat list
+			listFile = filepath.Join(ws.WorkDir, fmt.Sprintf("merge_part_%d_%d.txt", i+1, time.Now().UnixNano()))
+			f, err := os.Create(listFile)
+			if err != nil {
+				removeFiles(inputs.Temp)
+				skip(fmt.Errorf("part %d: %v", i+1, err))
+				continue
+			}
+			for _, pf := range inputs.Files {
+				abs, _ := filepath.Abs(pf)
+				_, _ = f.WriteString(fmt.Sprintf("file '%s'\n", utils.EscapeForFFmpeg(abs)))
+			}
+			f.Close()
 
-		tmpMerged := filepath.Join(output, fmt.Sprintf("Part%d_tmp.mkv", i+1))
-		// concat preserving streams
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
-		outb, err := ffmpeg.RunCmd(ctx, "ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-map", "0:v?", "-map", "0:a?", "-ignore_unknown", "-c", "copy", "-fflags", "+genpts", "-avoid_negative_ts", "make_zero", tmpMerged)
+			// concat preserving streams
+			concatCtx, cancel = context.WithTimeout(ctx, 15*time.Minute)
+			args = []string{"-y", "-f", "concat", "-safe", "0", "-i", listFile}
+			args = append(args, ffmpeg.CopyMapArgs()...)
+			args = append(args, "-ignore_unknown", "-c", "copy", "-fflags", "+genpts", "-avoid_negative_ts", "make_zero")
+		}
+		args = append(args, streams.Audio.DispositionArgs()...)
+		outb, err := ffmpeg.RunFFmpeg(concatCtx, tracker.Reporter(i), append(args, tmpMerged)...)
 		cancel()
-		_ = os.Remove(listFile)
+		removeFiles([]string{listFile}, inputs.Temp)
+		if ctx.Err() != nil {
+			_ = os.Remove(tmpMerged)
+			return ctx.Err()
+		}
 		if err != nil {
-			return fmt.Errorf("concat failed for part %d: %v (%s)", i+1, err, string(outb))
+			_ = os.Remove(tmpMerged)
+			skip(fmt.Errorf("concat failed for part %d: %v (%s)", i+1, err, ffmpeg.OutputTail(outb)))
+			continue
 		}
 
 		// Build combined chapters for this part
-		partMetaOut := filepath.Join(output, fmt.Sprintf("part_%d_chapters.txt", i+1))
+		partMetaOut := filepath.Join(ws.WorkDir, fmt.Sprintf("part_%d_chapters.txt", i+1))
 		if err := ffmpeg.BuildCombinedChapters(partMeta, partDur, partMetaOut); err != nil {
 			// if build failed, we can continue without chapters for this part
-			log.Printf("⚠️ buildCombinedChapters failed for part %d: %v", i+1, err)
+			job.Logf("⚠️ buildCombinedChapters failed for part %d: %v", i+1, err)
 			_ = os.Remove(partMetaOut)
 			partMetaOut = ""
 		}
+		expect := partExpectation(inputs.Ref, inputs.Filter, partDur, partMetaOut)
 
-		// apply chapters metadata to create final PartX.mkv
-		partFinal := filepath.Join(output, fmt.Sprintf("Part%d.mkv", i+1))
+		// the part is finished in the staging folder and only moved to its final name once verified
+		staged := filepath.Join(ws.Staging, filepath.Base(partFinal))
 		if partMetaOut != "" {
-			ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Minute)
-			outb2, err2 := ffmpeg.RunCmd(ctx2, "ffmpeg", "-y", "-i", tmpMerged, "-i", partMetaOut, "-map", "0:v?", "-map", "0:a?", "-ignore_unknown", "-map_metadata", "1", "-c", "copy", partFinal)
+			ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Minute)
+			args2 := []string{"-y", "-i", tmpMerged, "-i", partMetaOut}
+			args2 = append(args2, ffmpeg.CopyMapArgs()...)
+			args2 = append(args2, "-ignore_unknown", "-map_metadata", "1", "-c", "copy")
+			args2 = append(args2, streams.Audio.DispositionArgs()...)
+			outb2, err2 := ffmpeg.RunCmd(ctx2, "ffmpeg", append(args2, staged)...)
 			cancel2()
+			_ = os.Remove(partMetaOut)
+			if ctx.Err() != nil {
+				removeFiles([]string{tmpMerged, staged})
+				return ctx.Err()
+			}
 			if err2 != nil {
 				// fallback to tmpMerged
-				log.Printf("⚠️ failed apply chapters for part %d: %v (%s). Using tmp merged.", i+1, err2, string(outb2))
-				_ = os.Rename(tmpMerged, partFinal)
+				job.Logf("⚠️ failed apply chapters for part %d: %v (%s). Using tmp merged.", i+1, err2, ffmpeg.OutputTail(outb2))
+				_ = os.Rename(tmpMerged, staged)
 			} else {
 				_ = os.Remove(tmpMerged)
 			}
-			_ = os.Remove(partMetaOut)
 		} else {
-			_ = os.Rename(tmpMerged, partFinal)
-		}
-
-		// ✨ Extract all audio and subtitle tracks from the FINAL merged part
-		// log.Printf("🎵📝 Extracting tracks from Part%d.mkv...", i+1)
-
-		// // Extract audios
-		// audioMap, err := ffmpeg.ExtractAllAudioTracks(partFinal, output)
-		// if err != nil {
-		// 	log.Printf("⚠️ Audio extraction warning for Part%d: %v", i+1, err)
-		// } else {
-		// 	log.Printf("✅ Extracted %d audio track(s) from Part%d", len(audioMap), i+1)
-		// 	// Rename audio files to Part format
-		// 	renameExtractedTracks(audioMap, output, "audios", i+1, "mka")
-		// }
-
-		// // Extract subtitles
-		// subsMap, err := ffmpeg.ExtractAllSubtitles(partFinal, output)
-		// if err != nil {
-		// 	log.Printf("⚠️ Subtitle extraction warning for Part%d: %v", i+1, err)
-		// } else {
-		// 	log.Printf("✅ Extracted %d subtitle track(s) from Part%d", len(subsMap), i+1)
-		// 	// Rename subtitle files to Part format
-		// 	renameExtractedTracks(subsMap, output, "subtitles", i+1, "srt")
-		// }
-
-		models.ProgressState.Update(func(p *models.Progress) {
-			p.Completed++
-			if p.Total > 0 {
-				p.Percent = (float64(p.Completed) / float64(p.Total)) * 100
+			_ = os.Rename(tmpMerged, staged)
+		}
+
+		if err := commitStaged(ctx, staged, partFinal, "video"); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
+			skip(fmt.Errorf("part %d: %v", i+1, err))
+			continue
+		}
+		job.AddOutputs(models.OutputFile{Part: i + 1, Kind: "video", Path: partFinal})
+		job.UpdatePart(i+1, func(p *models.PartStatus) {
+			p.Output = partFinal
+			p.Verification = expect
 		})
-	}
 
-	// conservative cleanup: remove files matching trimmed naming pattern
-	for _, f := range processedFiles {
-		l := strings.ToLower(filepath.Base(f))
-		if strings.Contains(l, "_seg_") || strings.HasPrefix(l, "merged_") || strings.Contains(strings.ToLower(f), "tmp") {
-			_ = os.Remove(f)
+		// ✨ Extract all audio tracks from the FINAL merged part
+		if opts.ExportAudio {
+			job.Logf("🎵 Extracting audio tracks from %s...", filepath.Base(partFinal))
+			audioMap, err := ffmpeg.ExtractAllAudioTracks(ctx, partFinal, ws.Staging, partName, opts.AudioExportFormat)
+			if ctx.Err() != nil {
+				removeFiles(sortedValues(audioMap))
+				return ctx.Err()
+			}
+			if err != nil {
+				job.Logf("⚠️ Audio extraction warning for %s: %v", partName, err)
+			} else {
+				audioFiles := commitSidecars(ctx, job, ws, sortedValues(audioMap), "audio")
+				job.Logf("✅ Extracted %d audio track(s) from %s", len(audioFiles), partName)
+				job.AddOutputs(outputFiles(i+1, "audio", audioFiles)...)
+			}
 		}
+
+		// Write retimed sidecar subtitles for this part
+		subFiles, err := CombineSubtitles(partSubs, partDur, ws.Staging, partName)
+		if err != nil {
+			job.Logf("⚠️ Subtitle export warning for %s: %v", partName, err)
+		}
+		job.AddOutputs(outputFiles(i+1, "subtitle", commitSidecars(ctx, job, ws, subFiles, "subtitle"))...)
+		job.Emit(models.JobEvent{Type: "part", Part: i + 1, File: partFinal, State: "finished"})
+
+		tracker.Finish(i, true)
+	}
+
+	if len(skipped) > 0 {
+		return fmt.Errorf("part(s) %s were not merged, see the part errors", strings.Join(skipped, ", "))
 	}
 	return nil
 }
+
+// commitSidecars moves audio or subtitle files staged under ws.Staging to the same place under ws.Output
+// and returns the final paths of those that passed verification
+func commitSidecars(ctx context.Context, job *Job, ws Workspace, staged []string, kind string) []string {
+	var final []string
+	for _, f := range staged {
+		rel, err := filepath.Rel(ws.Staging, f)
+		if err != nil {
+			rel = filepath.Base(f)
+		}
+		dst := filepath.Join(ws.Output, rel)
+		if err := commitStaged(ctx, f, dst, kind); err != nil {
+			job.Logf("⚠️ %v", err)
+			continue
+		}
+		final = append(final, dst)
+	}
+	return final
+}
+
+// partPath names a part from the naming template and the numbers of its first and last episode, then
+// applies the collision policy. episodes maps trimmed files to their episode records
+func partPath(output string, naming Naming, part int, files []string, episodes map[string]models.EpisodeStatus, used map[string]bool) (string, error) {
+	number := func(f string) int {
+		ep := episodes[f]
+		return episodeNumber(ep.Input, ep.Episode)
+	}
+	name, err := naming.partName(part, number(files[0]), number(files[len(files)-1]))
+	if err != nil {
+		return "", err
+	}
+	return resolveCollision(filepath.Join(output, name+".mkv"), naming.Collision, used)
+}
+
+// partGroups splits n episodes into consecutive groups of ceil(n/parts) episodes and returns
+// the non-empty groups as [start, end) index pairs
+func partGroups(n, parts int) [][2]int {
+	// sanitize parts
+	if parts <= 0 {
+		parts = 1
+	}
+	if parts > n {
+		parts = n
+	}
+	if n == 0 {
+		return nil
+	}
+
+	partSize := (n + parts - 1) / parts
+	groups := [][2]int{}
+	for i := 0; i < parts; i++ {
+		start := i * partSize
+		end := utils.Min((i+1)*partSize, n)
+		if start >= end {
+			continue
+		}
+		groups = append(groups, [2]int{start, end})
+	}
+	return groups
+}
+
+// sum adds up values
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// outputFiles describes paths written for a part as job outputs of the given kind
+func outputFiles(part int, kind string, paths []string) []models.OutputFile {
+	files := make([]models.OutputFile, 0, len(paths))
+	for _, p := range paths {
+		files = append(files, models.OutputFile{Part: part, Kind: kind, Path: p})
+	}
+	return files
+}
+
+// sortedValues returns the values of a stream-index keyed map in stream order
+func sortedValues(m map[int]string) []string {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, m[k])
+	}
+	return values
+}
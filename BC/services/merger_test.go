@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestPartGroups(t *testing.T) {
+	tests := []struct {
+		n, parts int
+		want     [][2]int
+	}{
+		{12, 3, [][2]int{{0, 4}, {4, 8}, {8, 12}}},
+		{10, 4, [][2]int{{0, 3}, {3, 6}, {6, 9}, {9, 10}}},
+		{9, 6, [][2]int{{0, 2}, {2, 4}, {4, 6}, {6, 8}, {8, 9}}}, // trailing empty part dropped
+		{3, 0, [][2]int{{0, 3}}},
+		{2, 5, [][2]int{{0, 1}, {1, 2}}},
+		{0, 3, nil},
+	}
+	for _, tt := range tests {
+		if got := partGroups(tt.n, tt.parts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("partGroups(%d, %d) = %v, want %v", tt.n, tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestMergeEpisodesContinuesAfterConcatFailure(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out")
+	files := []string{filepath.Join(dir, "Episode 1.mkv"), filepath.Join(dir, "Episode 2.mkv")}
+	for _, f := range files {
+		makeEpisode(t, f, "320x240")
+	}
+	job := NewJobRegistry().Create(dir, output, models.TrimOptions{})
+	ws, err := newWorkspace(job, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a folder in place of part 1's concat output makes only that concat fail
+	if err := os.Mkdir(filepath.Join(ws.WorkDir, "Part1_tmp.mkv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	grouping := PartGrouping{Mode: GroupByCount, Parts: 2}
+	streams := ffmpeg.StreamPlan{Audio: ffmpeg.AudioPlan{Tracks: []int{0}}}
+	err = MergeEpisodes(context.Background(), job, files, []string{"", ""}, []float64{6, 6}, make([][]EpisodeSubtitle, 2), ws, grouping, streams)
+	if err == nil || !strings.Contains(err.Error(), "part(s) 1 were not merged") {
+		t.Fatalf("MergeEpisodes = %v, want part 1 reported as not merged", err)
+	}
+	job.Update(func(j *models.Job) { j.Error = err.Error() })
+
+	state := job.Snapshot()
+	if len(state.Parts) != 2 || state.Parts[0].Error == "" || state.Parts[1].Output == "" {
+		t.Fatalf("parts = %+v, want part 1 failed and part 2 merged", state.Parts)
+	}
+	if _, err := os.Stat(state.Parts[1].Output); err != nil {
+		t.Errorf("part 2 was not written: %v", err)
+	}
+	if outcome := jobOutcome(state); outcome != "partial" {
+		t.Errorf("job outcome = %q, want partial", outcome)
+	}
+}
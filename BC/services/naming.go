@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// Collision policies of NamingOptions.Collision, applied when a part's file name is taken
+const (
+	CollisionOverwrite = "overwrite" // replace the existing file
+	CollisionSuffix    = "suffix"    // append " (2)", " (3)", ... until the name is free
+	CollisionFail      = "fail"      // leave the part out of the job
+)
+
+// Default templates, matching the names used before templates existed
+const (
+	DefaultPartTemplate    = "Part{part}"
+	DefaultEpisodeTemplate = "merged_{name}"
+)
+
+// Naming is the resolved naming of a job's parts and trimmed episodes
+type Naming struct {
+	Part      string
+	Episode   string
+	Series    string
+	Season    int
+	Collision string
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+	seasonPattern      = regexp.MustCompile(`(?i)(?:season[ ._-]*|\bs)(\d{1,3})(?:\b|e\d)`)
+	episodePattern     = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:e|ep|episode)[ ._-]*(\d{1,4})`)
+	invalidFileChars   = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+)
+
+// ResolveNaming validates the naming templates and collision policy of a request. The series defaults
+// to the input folder's name and the season to the number in a "Season 2" or "S02" folder, else 1;
+// existing files are kept by suffixing new parts unless the request asks to overwrite them
+func ResolveNaming(input string, opts models.TrimOptions) (Naming, error) {
+	o := opts.Naming
+	n := Naming{Part: o.Part, Episode: o.Episode, Series: strings.TrimSpace(o.Series), Season: o.Season}
+	if n.Part == "" {
+		n.Part = DefaultPartTemplate
+	}
+	if n.Episode == "" {
+		n.Episode = DefaultEpisodeTemplate
+	}
+	if n.Series == "" {
+		n.Series = filepath.Base(filepath.Clean(strings.TrimSpace(input)))
+	}
+	if n.Season < 0 {
+		return Naming{}, fmt.Errorf("season must not be negative")
+	}
+	if n.Season == 0 {
+		n.Season = seasonNumber(input)
+	}
+
+	switch collision := strings.ToLower(strings.TrimSpace(o.Collision)); collision {
+	case "":
+		n.Collision = CollisionSuffix
+	case CollisionOverwrite, CollisionSuffix, CollisionFail:
+		n.Collision = collision
+	default:
+		return Naming{}, fmt.Errorf("unknown collision policy %q (use overwrite, suffix or fail)", o.Collision)
+	}
+
+	if _, err := n.partName(1, 1, 1); err != nil {
+		return Naming{}, fmt.Errorf("invalid part template: %v", err)
+	}
+	if _, err := n.episodeName(1, "Episode.mkv"); err != nil {
+		return Naming{}, fmt.Errorf("invalid episode template: %v", err)
+	}
+	if !strings.Contains(n.Episode, "{name") && !strings.Contains(n.Episode, "{ep") {
+		return Naming{}, fmt.Errorf("episode template must contain {name} or {ep} so episodes get distinct files")
+	}
+	return n, nil
+}
+
+// partName renders the part template (without extension)
+func (n Naming) partName(part, firstEp, lastEp int) (string, error) {
+	return renderTemplate(n.Part, map[string]any{
+		"series": n.Series, "season": n.Season, "part": part, "first_ep": firstEp, "last_ep": lastEp,
+	})
+}
+
+// episodeName renders the trimmed episode template (without extension) for the source file of episode ep (1-based)
+func (n Naming) episodeName(ep int, file string) (string, error) {
+	return renderTemplate(n.Episode, map[string]any{
+		"series": n.Series, "season": n.Season, "ep": episodeNumber(file, ep),
+		"name": strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+	})
+}
+
+// renderTemplate replaces {key} and zero-padded {key:02} placeholders with values and makes the
+// result safe to use as a file name
+func renderTemplate(tmpl string, values map[string]any) (string, error) {
+	var err error
+	out := placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		sub := placeholderPattern.FindStringSubmatch(m)
+		v, ok := values[sub[1]]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown placeholder %s", m)
+			}
+			return m
+		}
+		if num, isNum := v.(int); isNum && sub[2] != "" {
+			width, _ := strconv.Atoi(sub[2])
+			return fmt.Sprintf("%0*d", width, num)
+		}
+		return fmt.Sprint(v)
+	})
+	if err != nil {
+		return "", err
+	}
+	out = strings.TrimSpace(invalidFileChars.Replace(out))
+	if out == "" || out == "." || out == ".." {
+		return "", fmt.Errorf("template %q renders to an empty name", tmpl)
+	}
+	return out, nil
+}
+
+// seasonNumber returns the season number in the innermost folder of path that names one, or 1
+func seasonNumber(path string) int {
+	for dir := filepath.Clean(strings.TrimSpace(path)); ; dir = filepath.Dir(dir) {
+		if m := seasonPattern.FindStringSubmatch(filepath.Base(dir)); m != nil {
+			season, _ := strconv.Atoi(m[1])
+			return season
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return 1
+		}
+	}
+}
+
+// episodeNumber returns the episode number in a file name such as "Show S01E05.mkv" or "Episode 5.mkv",
+// or fallback when it has none
+func episodeNumber(file string, fallback int) int {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if m := episodePattern.FindStringSubmatch(name); m != nil {
+		ep, _ := strconv.Atoi(m[1])
+		return ep
+	}
+	return fallback
+}
+
+// resolveCollision returns the path to write a final file to under policy. used holds the paths
+// earlier parts of the job were written to; they are never overwritten
+func resolveCollision(path, policy string, used map[string]bool) (string, error) {
+	_, err := os.Stat(path)
+	taken := used[path] || err == nil
+	switch {
+	case !taken || policy == CollisionOverwrite && !used[path]:
+		return path, nil
+	case policy == CollisionFail:
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) && !used[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// reserveFile creates an empty file at path, or at path with a " (2)", " (3)", ... suffix when it
+// exists, and returns the path created. Episodes trimmed concurrently never get the same file
+func reserveFile(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(candidate, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
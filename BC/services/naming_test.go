@@ -0,0 +1,158 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveNamingDefaults(t *testing.T) {
+	n, err := ResolveNaming("/media/Show/Season 2", models.TrimOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Naming{Part: DefaultPartTemplate, Episode: DefaultEpisodeTemplate, Series: "Season 2", Season: 2, Collision: CollisionSuffix}
+	if n != want {
+		t.Errorf("ResolveNaming = %+v, want %+v", n, want)
+	}
+	if name, _ := n.partName(3, 25, 36); name != "Part3" {
+		t.Errorf("default part name = %q", name)
+	}
+	if name, _ := n.episodeName(1, "/media/Show/Season 2/Show S02E25.mkv"); name != "merged_Show S02E25" {
+		t.Errorf("default episode name = %q", name)
+	}
+}
+
+func TestResolveNamingTemplates(t *testing.T) {
+	opts := models.TrimOptions{Naming: models.NamingOptions{
+		Part:      "{series} S{season:02} - Part {part:02} (E{first_ep:02}-E{last_ep:02})",
+		Episode:   "trim_{ep:03}",
+		Series:    "My Show",
+		Collision: "Suffix",
+	}}
+	n, err := ResolveNaming("/media/S01", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Season != 1 || n.Collision != CollisionSuffix {
+		t.Errorf("ResolveNaming = %+v", n)
+	}
+	if name, _ := n.partName(2, 5, 8); name != "My Show S01 - Part 02 (E05-E08)" {
+		t.Errorf("part name = %q", name)
+	}
+	if name, _ := n.episodeName(4, "Show - Episode 7.mkv"); name != "trim_007" {
+		t.Errorf("episode name = %q", name)
+	}
+	if name, _ := n.episodeName(4, "Show - Finale.mkv"); name != "trim_004" {
+		t.Errorf("episode name without a number = %q, want the position", name)
+	}
+
+	for _, bad := range []models.NamingOptions{
+		{Part: "Part {volume}"},
+		{Part: " "},
+		{Episode: "episode"},
+		{Collision: "rename"},
+		{Season: -1},
+	} {
+		if _, err := ResolveNaming("/media/Show", models.TrimOptions{Naming: bad}); err == nil {
+			t.Errorf("ResolveNaming(%+v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestRenderTemplateSanitizes(t *testing.T) {
+	got, err := renderTemplate("{series}: {part}", map[string]any{"series": "A/B", "part": 1})
+	if err != nil || got != "A_B_ 1" {
+		t.Errorf("renderTemplate = %q, %v", got, err)
+	}
+}
+
+func TestSeasonAndEpisodeNumbers(t *testing.T) {
+	for path, want := range map[string]int{
+		"/media/Show/Season 3":       3,
+		"/media/Show S04/extras":     4,
+		"/media/Show.S05E01-E12":     5,
+		"/media/Show":                1,
+		"/media/Seasonal Specials 2": 1,
+	} {
+		if got := seasonNumber(path); got != want {
+			t.Errorf("seasonNumber(%q) = %d, want %d", path, got, want)
+		}
+	}
+	for file, want := range map[string]int{
+		"Show S01E05.mkv":       5,
+		"Show - Episode 12.mkv": 12,
+		"Show ep.3.mkv":         3,
+		"Show - 07.mkv":         99,
+		"Else 2.mkv":            99,
+	} {
+		if got := episodeNumber(file, 99); got != want {
+			t.Errorf("episodeNumber(%q) = %d, want %d", file, got, want)
+		}
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Part1.mkv")
+	if got, err := resolveCollision(path, CollisionFail, nil); err != nil || got != path {
+		t.Errorf("free name under fail = %q, %v", got, err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := resolveCollision(path, CollisionOverwrite, nil); err != nil || got != path {
+		t.Errorf("overwrite = %q, %v", got, err)
+	}
+	if _, err := resolveCollision(path, CollisionFail, nil); err == nil {
+		t.Error("fail should refuse an existing file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Part1 (2).mkv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := resolveCollision(path, CollisionSuffix, nil); err != nil || got != filepath.Join(dir, "Part1 (3).mkv") {
+		t.Errorf("suffix = %q, %v", got, err)
+	}
+
+	// a name written by an earlier part of the same job is never overwritten
+	used := map[string]bool{filepath.Join(dir, "Part2.mkv"): true}
+	if got, _ := resolveCollision(filepath.Join(dir, "Part2.mkv"), CollisionOverwrite, used); got != filepath.Join(dir, "Part2 (2).mkv") {
+		t.Errorf("overwrite of a used name = %q", got)
+	}
+}
+
+func TestDefaultNamingKeepsExistingParts(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "Part1.mkv")
+	if err := os.WriteFile(existing, []byte("earlier run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	naming, err := ResolveNaming(dir, models.TrimOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes := map[string]models.EpisodeStatus{"a.mkv": {Episode: 1, Input: "Episode 1.mkv"}}
+	got, err := partPath(dir, naming, 1, []string{"a.mkv"}, episodes, map[string]bool{})
+	if err != nil || got != filepath.Join(dir, "Part1 (2).mkv") {
+		t.Errorf("part path under the default options = %q, %v; want Part1 (2).mkv", got, err)
+	}
+}
+
+func TestReserveFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "merged_E1.mkv")
+	first, err := reserveFile(path)
+	if err != nil || first != path {
+		t.Fatalf("reserveFile = %q, %v", first, err)
+	}
+	second, err := reserveFile(path)
+	if err != nil || second != filepath.Join(dir, "merged_E1 (2).mkv") {
+		t.Fatalf("second reserveFile = %q, %v", second, err)
+	}
+	if _, err := os.Stat(second); err != nil {
+		t.Error("reserved file was not created")
+	}
+}
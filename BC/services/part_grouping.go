@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// Part grouping modes of TrimOptions.Grouping. Every mode keeps the episode order
+const (
+	GroupByCount    = "count"    // Parts parts of ceil(n/Parts) episodes
+	GroupBalanced   = "balanced" // Parts parts with runtimes as even as possible
+	GroupByDuration = "duration" // as many parts as needed to come closest to PartDuration each
+)
+
+// PartGrouping is the resolved way trimmed episodes are grouped into parts
+type PartGrouping struct {
+	Mode   string
+	Parts  int
+	Target float64 // target part runtime in seconds for GroupByDuration
+}
+
+// ResolvePartGrouping validates the grouping mode and target part duration of a request
+func ResolvePartGrouping(opts models.TrimOptions) (PartGrouping, error) {
+	mode := strings.ToLower(strings.TrimSpace(opts.Grouping))
+	if opts.PartDuration != "" && mode != GroupByDuration {
+		return PartGrouping{}, fmt.Errorf("partDuration needs grouping %q", GroupByDuration)
+	}
+	switch mode {
+	case "", GroupByCount:
+		return PartGrouping{Mode: GroupByCount, Parts: opts.Parts}, nil
+	case GroupBalanced:
+		return PartGrouping{Mode: mode, Parts: opts.Parts}, nil
+	case GroupByDuration:
+		if opts.PartDuration == "" {
+			return PartGrouping{}, fmt.Errorf("grouping %q needs a partDuration such as \"2h\" or \"1:30:00\"", GroupByDuration)
+		}
+		target, err := ffmpeg.ParseTimestamp(opts.PartDuration)
+		if err != nil {
+			return PartGrouping{}, fmt.Errorf("invalid partDuration %q: %v", opts.PartDuration, err)
+		}
+		if target <= 0 {
+			return PartGrouping{}, fmt.Errorf("partDuration must be positive")
+		}
+		return PartGrouping{Mode: mode, Target: target}, nil
+	default:
+		return PartGrouping{}, fmt.Errorf("unknown grouping %q (use count, balanced or duration)", opts.Grouping)
+	}
+}
+
+// Groups splits episodes with the given trimmed runtimes into consecutive parts and returns
+// them as [start, end) index pairs. Without runtimes it falls back to grouping by count
+func (g PartGrouping) Groups(durations []float64) [][2]int {
+	if g.Mode == GroupByCount || sum(durations) <= 0 {
+		return partGroups(len(durations), g.Parts)
+	}
+	if g.Mode == GroupByDuration {
+		return targetGroups(durations, g.Target)
+	}
+	return balancedGroups(durations, g.Parts)
+}
+
+// balancedGroups splits durations into min(parts, n) consecutive groups minimising the sum of squared
+// part runtimes, which for a fixed total makes the runtimes as even as possible
+func balancedGroups(durations []float64, parts int) [][2]int {
+	n := len(durations)
+	parts = min(max(parts, 1), n)
+	if n == 0 {
+		return nil
+	}
+	prefix := prefixSums(durations)
+
+	// cost[k][i] is the best cost of splitting the first i episodes into k parts, cut[k][i] its last split
+	cost := make([][]float64, parts+1)
+	cut := make([][]int, parts+1)
+	for k := range cost {
+		cost[k] = make([]float64, n+1)
+		cut[k] = make([]int, n+1)
+		for i := range cost[k] {
+			cost[k][i] = -1
+		}
+	}
+	cost[0][0] = 0
+	for k := 1; k <= parts; k++ {
+		for i := k; i <= n; i++ {
+			for m := k - 1; m < i; m++ {
+				if cost[k-1][m] < 0 {
+					continue
+				}
+				run := prefix[i] - prefix[m]
+				if c := cost[k-1][m] + run*run; cost[k][i] < 0 || c < cost[k][i] {
+					cost[k][i], cut[k][i] = c, m
+				}
+			}
+		}
+	}
+
+	groups := make([][2]int, parts)
+	for k, end := parts, n; k > 0; k-- {
+		groups[k-1] = [2]int{cut[k][end], end}
+		end = cut[k][end]
+	}
+	return groups
+}
+
+// targetGroups splits durations into consecutive groups, choosing the number of parts and the split
+// points that minimise the sum of squared differences between each part's runtime and target
+func targetGroups(durations []float64, target float64) [][2]int {
+	n := len(durations)
+	if n == 0 {
+		return nil
+	}
+	prefix := prefixSums(durations)
+
+	// cost[i] is the best cost for the first i episodes, cut[i] the start of their last part
+	cost := make([]float64, n+1)
+	cut := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		cost[i] = -1
+		for m := 0; m < i; m++ {
+			diff := prefix[i] - prefix[m] - target
+			if c := cost[m] + diff*diff; cost[i] < 0 || c < cost[i] {
+				cost[i], cut[i] = c, m
+			}
+		}
+	}
+
+	var groups [][2]int
+	for end := n; end > 0; end = cut[end] {
+		groups = append([][2]int{{cut[end], end}}, groups...)
+	}
+	return groups
+}
+
+// prefixSums returns the running totals of values, starting with 0
+func prefixSums(values []float64) []float64 {
+	prefix := make([]float64, len(values)+1)
+	for i, v := range values {
+		prefix[i+1] = prefix[i] + v
+	}
+	return prefix
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolvePartGrouping(t *testing.T) {
+	tests := []struct {
+		opts models.TrimOptions
+		want PartGrouping
+	}{
+		{models.TrimOptions{Parts: 3}, PartGrouping{Mode: GroupByCount, Parts: 3}},
+		{models.TrimOptions{Parts: 2, Grouping: " Balanced"}, PartGrouping{Mode: GroupBalanced, Parts: 2}},
+		{models.TrimOptions{Grouping: "duration", PartDuration: "2h"}, PartGrouping{Mode: GroupByDuration, Target: 7200}},
+		{models.TrimOptions{Grouping: "duration", PartDuration: "1:30:00"}, PartGrouping{Mode: GroupByDuration, Target: 5400}},
+	}
+	for _, tt := range tests {
+		if got, err := ResolvePartGrouping(tt.opts); err != nil || got != tt.want {
+			t.Errorf("ResolvePartGrouping(%+v) = %+v, %v; want %+v", tt.opts, got, err, tt.want)
+		}
+	}
+	for _, opts := range []models.TrimOptions{
+		{Grouping: "random"},
+		{Grouping: "duration"},
+		{Grouping: "duration", PartDuration: "0"},
+		{Grouping: "duration", PartDuration: "soon"},
+		{PartDuration: "2h"},
+	} {
+		if _, err := ResolvePartGrouping(opts); err == nil {
+			t.Errorf("ResolvePartGrouping(%+v) succeeded, want an error", opts)
+		}
+	}
+}
+
+func TestBalancedGroups(t *testing.T) {
+	tests := []struct {
+		durations []float64
+		parts     int
+		want      [][2]int
+	}{
+		// by count this would be {0,2},{2,4} with runtimes 100 and 40
+		{[]float64{60, 40, 20, 20}, 2, [][2]int{{0, 1}, {1, 4}}},
+		{[]float64{10, 10, 10, 10, 10, 10}, 3, [][2]int{{0, 2}, {2, 4}, {4, 6}}},
+		{[]float64{30, 30}, 5, [][2]int{{0, 1}, {1, 2}}},
+		{[]float64{30, 30}, 0, [][2]int{{0, 2}}},
+		{nil, 2, nil},
+	}
+	for _, tt := range tests {
+		if got := balancedGroups(tt.durations, tt.parts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("balancedGroups(%v, %d) = %v, want %v", tt.durations, tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestTargetGroups(t *testing.T) {
+	tests := []struct {
+		durations []float64
+		target    float64
+		want      [][2]int
+	}{
+		{[]float64{40, 40, 40, 40, 40, 40}, 120, [][2]int{{0, 3}, {3, 6}}},
+		{[]float64{40, 40, 40, 40, 40, 40, 40}, 120, [][2]int{{0, 3}, {3, 7}}},
+		{[]float64{100, 30, 90, 30}, 120, [][2]int{{0, 2}, {2, 4}}},
+		{[]float64{50}, 7200, [][2]int{{0, 1}}},
+		{nil, 60, nil},
+	}
+	for _, tt := range tests {
+		if got := targetGroups(tt.durations, tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("targetGroups(%v, %v) = %v, want %v", tt.durations, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPartGroupingFallsBackToCount(t *testing.T) {
+	g := PartGrouping{Mode: GroupBalanced, Parts: 2}
+	if got := g.Groups([]float64{0, 0, 0}); !reflect.DeepEqual(got, partGroups(3, 2)) {
+		t.Errorf("Groups without runtimes = %v, want %v", got, partGroups(3, 2))
+	}
+}
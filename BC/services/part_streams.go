@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// partInputs are the files a part is joined from once the concat policy has been applied
+type partInputs struct {
+	Files  []string              // the part's episodes, outliers replaced by conformed copies
+	Filter bool                  // join with the concat filter instead of the concat demuxer
+	Ref    []ffmpeg.StreamParams // stream layout the part is joined to
+	Temp   []string              // conformed copies to remove once the part is written
+}
+
+// checkPartStreams probes every episode of a part, records how the episodes differ from the layout most
+// of them share and applies policy to the outliers, writing conformed copies to workDir.
+// An error means the part cannot be joined
+func checkPartStreams(ctx context.Context, job *Job, part int, files []string, workDir, policy string) (partInputs, error) {
+	probes := make([][]ffmpeg.StreamParams, len(files))
+	for i, f := range files {
+		streams, err := ffmpeg.ProbeStreams(ctx, f)
+		if err != nil {
+			return partInputs{}, fmt.Errorf("part %d: probing %s failed: %v", part, filepath.Base(f), err)
+		}
+		probes[i] = streams
+	}
+	ref := ffmpeg.ReferenceStreams(probes)
+	in := partInputs{Files: append([]string(nil), files...), Ref: probes[ref]}
+
+	var outliers []int
+	var mismatches []string
+	for i := range files {
+		diffs := ffmpeg.CompareStreams(in.Ref, probes[i])
+		if len(diffs) == 0 {
+			continue
+		}
+		outliers = append(outliers, i)
+		for _, d := range diffs {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s", filepath.Base(files[i]), d))
+		}
+	}
+	action := "copy"
+	if len(outliers) > 0 {
+		action = policy
+		if policy == ffmpeg.ConcatRefuse {
+			action = "refused"
+		}
+	}
+	job.UpdatePart(part, func(p *models.PartStatus) {
+		p.Episodes = append([]string(nil), files...)
+		p.Mismatches = mismatches
+		p.Action = action
+	})
+	if len(outliers) == 0 {
+		return in, nil
+	}
+	job.Logf("⚠️ Part %d: %d episode(s) differ from %s: %s", part, len(outliers), filepath.Base(files[ref]), strings.Join(mismatches, "; "))
+
+	if policy == ffmpeg.ConcatRefuse {
+		return in, fmt.Errorf("part %d: %d episode(s) have mismatched streams (use concatPolicy reencode or filter to merge them anyway)", part, len(outliers))
+	}
+	for _, i := range outliers {
+		if !ffmpeg.SameStreamCounts(in.Ref, probes[i]) {
+			return in, fmt.Errorf("part %d: %s has a different number of streams, which %s cannot make up for", part, filepath.Base(files[i]), policy)
+		}
+	}
+	if policy == ffmpeg.ConcatFilter {
+		in.Filter = true
+		return in, nil
+	}
+
+	var leftover []string // differences the encoder could not remove
+	for _, i := range outliers {
+		conformed := filepath.Join(workDir, strings.TrimSuffix(filepath.Base(files[i]), filepath.Ext(files[i]))+"_conform.mkv")
+		job.Logf("🔄 Re-encoding %s to match the other episodes of part %d...", filepath.Base(files[i]), part)
+		if err := ffmpeg.ConformFile(ctx, files[i], conformed, in.Ref, nil); err != nil {
+			_ = os.Remove(conformed)
+			removeFiles(in.Temp)
+			return in, fmt.Errorf("part %d: %v", part, err)
+		}
+		in.Files[i] = conformed
+		in.Temp = append(in.Temp, conformed)
+
+		streams, err := ffmpeg.ProbeStreams(ctx, conformed)
+		if err != nil {
+			removeFiles(in.Temp)
+			return in, fmt.Errorf("part %d: probing %s failed: %v", part, filepath.Base(conformed), err)
+		}
+		for _, d := range ffmpeg.CompareStreams(in.Ref, streams) {
+			leftover = append(leftover, fmt.Sprintf("%s (re-encoded): %s", filepath.Base(files[i]), d))
+		}
+	}
+	if len(leftover) > 0 {
+		// e.g. HE-AAC or a profile the encoder cannot produce; the concat filter does not need them to match
+		job.Logf("⚠️ Part %d still differs after re-encoding (%s), joining it with the concat filter instead", part, strings.Join(leftover, "; "))
+		removeFiles(in.Temp)
+		in.Files, in.Temp, in.Filter = append([]string(nil), files...), nil, true
+		job.UpdatePart(part, func(p *models.PartStatus) {
+			p.Mismatches = append(p.Mismatches, leftover...)
+			p.Action = ffmpeg.ConcatFilter
+		})
+		return in, nil
+	}
+
+	// the re-encoded episodes carry the encoder's parameter sets in-band; the copied ones get theirs
+	// written in-band too, since the concat demuxer only keeps the first file's
+	codec := ""
+	for _, st := range in.Ref {
+		if st.Type == "video" {
+			codec = st.Codec
+			break
+		}
+	}
+	if codec == "h264" || codec == "hevc" {
+		conformed := map[int]bool{}
+		for _, i := range outliers {
+			conformed[i] = true
+		}
+		for i, f := range files {
+			if conformed[i] {
+				continue
+			}
+			inband := filepath.Join(workDir, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))+"_inband.mkv")
+			if err := ffmpeg.InbandHeaders(ctx, f, inband, codec); err != nil {
+				_ = os.Remove(inband)
+				removeFiles(in.Temp)
+				return in, fmt.Errorf("part %d: %v", part, err)
+			}
+			in.Files[i] = inband
+			in.Temp = append(in.Temp, inband)
+		}
+	}
+	return in, nil
+}
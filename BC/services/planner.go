@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/utils"
+)
+
+// PlanEpisodes is a dry run of ProcessEpisodes: it scans every episode and reports the keep segments,
+// removed and resulting durations and the part grouping MergeEpisodes would produce, without writing anything
+func PlanEpisodes(ctx context.Context, input string, opts models.TrimOptions) (*models.Plan, error) {
+	files, err := utils.ListMKVFiles(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list episodes in %s: %v", input, err)
+	}
+
+	plan := &models.Plan{Episodes: make([]models.EpisodePlan, 0, len(files))}
+	var mergeable []int
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		ep := planEpisode(ctx, file, opts)
+		if ep.Error == "" {
+			mergeable = append(mergeable, len(plan.Episodes))
+			plan.RemovedDuration += ep.RemovedDuration
+			plan.ResultDuration += ep.ResultDuration
+		}
+		if ep.Error != "" || len(ep.Warnings) > 0 {
+			plan.Flagged++
+		}
+		plan.Episodes = append(plan.Episodes, ep)
+	}
+
+	grouping, err := ResolvePartGrouping(opts)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := ResolveNaming(input, opts)
+	if err != nil {
+		return nil, err
+	}
+	durations := make([]float64, len(mergeable))
+	for i, idx := range mergeable {
+		durations[i] = plan.Episodes[idx].ResultDuration
+	}
+
+	plan.Parts = []models.PartPlan{}
+	for i, group := range grouping.Groups(durations) {
+		part := models.PartPlan{Part: i + 1, Episodes: []string{}}
+		for _, idx := range mergeable[group[0]:group[1]] {
+			part.Episodes = append(part.Episodes, plan.Episodes[idx].File)
+			part.Duration += plan.Episodes[idx].ResultDuration
+		}
+		first, last := mergeable[group[0]], mergeable[group[1]-1]
+		name, _ := naming.partName(i+1, episodeNumber(plan.Episodes[first].File, first+1), episodeNumber(plan.Episodes[last].File, last+1))
+		part.Name = name + ".mkv"
+		plan.Parts = append(plan.Parts, part)
+	}
+	return plan, nil
+}
+
+// planEpisode scans a single episode and computes what trimming would keep
+func planEpisode(ctx context.Context, file string, opts models.TrimOptions) models.EpisodePlan {
+	ep := models.EpisodePlan{File: file, KeepSegments: []models.Segment{}}
+	ch, err := ffmpeg.ScanChapters(ctx, file)
+	if err != nil {
+		ep.Error = fmt.Sprintf("scan failed: %v", err)
+		return ep
+	}
+	if _, err := ffmpeg.ResolveStreamPlan(ctx, file, opts); err != nil {
+		ep.Error = err.Error()
+		return ep
+	}
+
+	ranges, errs := ffmpeg.ResolveSkipRanges(ch, opts.SkipRanges)
+	for _, err := range errs {
+		ep.Warnings = append(ep.Warnings, err.Error())
+	}
+
+	ep.Duration = ch["End"]
+	ep.KeepSegments = ffmpeg.KeepSegments(ep.Duration, ranges)
+	for _, seg := range ep.KeepSegments {
+		ep.ResultDuration += seg.End - seg.Start
+	}
+	ep.RemovedDuration = ep.Duration - ep.ResultDuration
+	return ep
+}
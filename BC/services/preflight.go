@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/utils"
+)
+
+// Disk checks of TrimOptions.DiskCheck
+const (
+	DiskRefuse = "refuse" // fail the job before trimming when the estimate exceeds the free space
+	DiskWarn   = "warn"   // log a warning and start anyway
+	DiskOff    = "off"    // skip the preflight
+)
+
+// ResolveDiskCheck validates the disk check of a request
+func ResolveDiskCheck(opts models.TrimOptions) (string, error) {
+	switch check := strings.ToLower(strings.TrimSpace(opts.DiskCheck)); check {
+	case "":
+		return DiskRefuse, nil
+	case DiskRefuse, DiskWarn, DiskOff:
+		return check, nil
+	default:
+		return "", fmt.Errorf("unknown disk check %q (use refuse, warn or off)", opts.DiskCheck)
+	}
+}
+
+// episodeSize is the source size of an episode and the fraction of its runtime trimming keeps
+type episodeSize struct {
+	Size int64
+	Keep float64 // kept seconds
+	Of   float64 // source seconds
+}
+
+// trimmed estimates the size of the trimmed episode, assuming an even bitrate
+func (e episodeSize) trimmed() int64 {
+	if e.Of <= 0 {
+		return e.Size
+	}
+	return int64(float64(e.Size) * min(e.Keep/e.Of, 1))
+}
+
+// estimateSpace estimates the disk space of a job. Trimmed episodes stay until the job ends; on top of
+// them the episodes being trimmed by the workers hold their segments, and a part being merged exists
+// twice (before and after its chapters are applied). The final parts add up to the trimmed episodes
+func estimateSpace(episodes []episodeSize, grouping PartGrouping, workers int) models.SpaceEstimate {
+	sizes := make([]int64, len(episodes))
+	durations := make([]float64, len(episodes))
+	var total int64
+	for i, ep := range episodes {
+		sizes[i] = ep.trimmed()
+		durations[i] = ep.Keep
+		total += sizes[i]
+	}
+
+	// the largest episodes may be trimmed at the same time
+	largest := append([]int64(nil), sizes...)
+	sort.Slice(largest, func(i, j int) bool { return largest[i] > largest[j] })
+	var trimming int64
+	for _, size := range largest[:min(max(workers, 1), len(largest))] {
+		trimming += size
+	}
+
+	var merging int64
+	for _, group := range grouping.Groups(durations) {
+		var part int64
+		for _, size := range sizes[group[0]:group[1]] {
+			part += size
+		}
+		merging = max(merging, 2*part)
+	}
+	return models.SpaceEstimate{Temp: total + max(trimming, merging), Final: total}
+}
+
+// checkSpace compares an estimate with the free space and describes the shortfall, if any
+func checkSpace(est models.SpaceEstimate) error {
+	if est.SameFilesystem {
+		if need := est.Temp + est.Final; need > est.OutputFree {
+			return fmt.Errorf("the job needs about %s but only %s is free in the output folder", formatBytes(need), formatBytes(est.OutputFree))
+		}
+		return nil
+	}
+	var short []string
+	if est.Temp > est.TempFree {
+		short = append(short, fmt.Sprintf("about %s of temporary files with %s free", formatBytes(est.Temp), formatBytes(est.TempFree)))
+	}
+	if est.Final > est.OutputFree {
+		short = append(short, fmt.Sprintf("about %s of parts with %s free in the output folder", formatBytes(est.Final), formatBytes(est.OutputFree)))
+	}
+	if len(short) > 0 {
+		return fmt.Errorf("the job needs %s", strings.Join(short, " and "))
+	}
+	return nil
+}
+
+// measureEpisodes reads the size of every episode and scans how much of it trimming keeps. The scans
+// run in parallel, each taking a slot of jobSlots (if any) and of the shared pool like a trim would.
+// Episodes that cannot be read are left out
+func measureEpisodes(ctx context.Context, job *Job, files []string, opts models.TrimOptions, jobSlots *WorkerPool) ([]episodeSize, error) {
+	sizes := make([]*episodeSize, len(files))
+	var wg sync.WaitGroup
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		sizes[i] = &episodeSize{Size: info.Size()}
+		wg.Add(1)
+		go func(ep *episodeSize, file string) {
+			defer wg.Done()
+			release, err := acquireWorker(ctx, jobSlots, job.ID())
+			if err != nil {
+				return
+			}
+			defer release()
+			// an episode that cannot be scanned is counted whole
+			if ch, err := ffmpeg.ScanChapters(ctx, file); err == nil {
+				ep.Of = ch["End"]
+				for _, seg := range ffmpeg.ComputeKeepSegments(ch, opts.SkipRanges) {
+					ep.Keep += seg.End - seg.Start
+				}
+			}
+		}(sizes[i], file)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	episodes := make([]episodeSize, 0, len(files))
+	for _, ep := range sizes {
+		if ep != nil {
+			episodes = append(episodes, *ep)
+		}
+	}
+	return episodes, nil
+}
+
+// preflightSpace estimates the space the job needs from the source sizes and the segments trimming keeps,
+// records the estimate on the job and checks it against the free space of the work and output folders.
+// Under DiskRefuse a shortfall is returned as an error; a free space that cannot be read is only a warning
+func preflightSpace(ctx context.Context, job *Job, files []string, ws Workspace, opts models.TrimOptions, jobSlots *WorkerPool, workers int) error {
+	check, _ := ResolveDiskCheck(opts) // validated with the request
+	if check == DiskOff {
+		return nil
+	}
+	episodes, err := measureEpisodes(ctx, job, files, opts, jobSlots)
+	if err != nil {
+		return err
+	}
+
+	grouping, _ := ResolvePartGrouping(opts) // validated with the request
+	est := estimateSpace(episodes, grouping, workers)
+	if est.TempFree, err = utils.FreeSpace(ws.WorkDir); err != nil {
+		job.Warnf("⚠️ Cannot check the free space of %s, starting without the disk check: %v", ws.WorkDir, err)
+		return nil
+	}
+	if est.OutputFree, err = utils.FreeSpace(ws.Output); err != nil {
+		job.Warnf("⚠️ Cannot check the free space of %s, starting without the disk check: %v", ws.Output, err)
+		return nil
+	}
+	est.SameFilesystem = utils.SameFilesystem(ws.WorkDir, ws.Output)
+	job.Update(func(j *models.Job) {
+		j.Space = &est
+	})
+	job.Logf("💽 Estimated space: %s temporary, %s of parts (%s free in %s)",
+		formatBytes(est.Temp), formatBytes(est.Final), formatBytes(est.OutputFree), filepath.Base(ws.Output))
+
+	if err := checkSpace(est); err != nil {
+		if check == DiskRefuse {
+			return fmt.Errorf("not enough disk space: %v (set diskCheck to \"warn\" to start anyway)", err)
+		}
+		job.Warnf("⚠️ Low disk space: %v", err)
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "3.2 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
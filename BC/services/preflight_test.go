@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveDiskCheck(t *testing.T) {
+	for in, want := range map[string]string{"": DiskRefuse, "Warn": DiskWarn, "off": DiskOff} {
+		if got, err := ResolveDiskCheck(models.TrimOptions{DiskCheck: in}); err != nil || got != want {
+			t.Errorf("ResolveDiskCheck(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ResolveDiskCheck(models.TrimOptions{DiskCheck: "maybe"}); err == nil {
+		t.Error("unknown disk check accepted")
+	}
+}
+
+func TestEstimateSpace(t *testing.T) {
+	const gb = 1 << 30
+	// four 2 GiB episodes keeping 75%, 75%, 50% and all of their runtime
+	episodes := []episodeSize{
+		{Size: 2 * gb, Keep: 900, Of: 1200},
+		{Size: 2 * gb, Keep: 900, Of: 1200},
+		{Size: 2 * gb, Keep: 600, Of: 1200},
+		{Size: 2 * gb, Keep: 1200, Of: 1200},
+	}
+	trimmed := int64(1.5*gb + 1.5*gb + 1*gb + 2*gb)
+
+	est := estimateSpace(episodes, PartGrouping{Mode: GroupByCount, Parts: 2}, 2)
+	if est.Final != trimmed {
+		t.Errorf("final = %d, want %d", est.Final, trimmed)
+	}
+	// two parts of 3 GiB: merging one needs 6 GiB, more than trimming the two largest episodes (3.5 GiB)
+	if want := trimmed + 6*gb; est.Temp != want {
+		t.Errorf("temp = %d, want %d", est.Temp, want)
+	}
+
+	// with one part per episode, four workers trimming at once need the most
+	est = estimateSpace(episodes, PartGrouping{Mode: GroupByCount, Parts: 4}, 4)
+	if want := 2 * trimmed; est.Temp != want {
+		t.Errorf("temp = %d, want %d", est.Temp, want)
+	}
+
+	// an episode that could not be scanned counts whole
+	if got := (episodeSize{Size: 100}).trimmed(); got != 100 {
+		t.Errorf("unscanned episode = %d, want 100", got)
+	}
+	if est := estimateSpace(nil, PartGrouping{Mode: GroupByCount, Parts: 1}, 4); est.Temp != 0 || est.Final != 0 {
+		t.Errorf("no episodes = %+v", est)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	shared := models.SpaceEstimate{Temp: 60, Final: 40, OutputFree: 100, SameFilesystem: true}
+	if err := checkSpace(shared); err != nil {
+		t.Errorf("enough shared space: %v", err)
+	}
+	shared.OutputFree = 99
+	if err := checkSpace(shared); err == nil {
+		t.Error("shared shortfall passed")
+	}
+
+	split := models.SpaceEstimate{Temp: 60, Final: 40, TempFree: 60, OutputFree: 40}
+	if err := checkSpace(split); err != nil {
+		t.Errorf("enough space on both filesystems: %v", err)
+	}
+	split.TempFree = 10
+	if err := checkSpace(split); err == nil {
+		t.Error("temp shortfall passed")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestMeasureEpisodes(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for name, size := range map[string]int{"Episode 1.mkv": 300, "Episode 3.mkv": 100} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"Episode 1.mkv", "Episode 2.mkv", "Episode 3.mkv"} {
+		files = append(files, filepath.Join(dir, name))
+	}
+	job := NewJobRegistry().Create(dir, dir, models.TrimOptions{})
+
+	// the files are no videos, so they are counted whole; the missing one is left out
+	episodes, err := measureEpisodes(context.Background(), job, files, models.TrimOptions{}, NewWorkerPool(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 2 || episodes[0].Size != 300 || episodes[1].Size != 100 || episodes[0].trimmed() != 300 {
+		t.Errorf("measureEpisodes = %+v, want sizes 300 and 100 counted whole", episodes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := measureEpisodes(ctx, job, files, models.TrimOptions{}, nil); err != context.Canceled {
+		t.Errorf("cancelled measureEpisodes returned %v", err)
+	}
+}
+
+func TestPreflightSpaceWarnsWithoutFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	job := NewJobRegistry().Create(dir, dir, models.TrimOptions{})
+	ws := Workspace{Output: dir, WorkDir: filepath.Join(dir, "missing")}
+	if err := preflightSpace(context.Background(), job, nil, ws, models.TrimOptions{}, nil, 1); err != nil {
+		t.Fatalf("preflightSpace failed the job: %v", err)
+	}
+
+	replay, _, unsubscribe := job.Subscribe(0)
+	unsubscribe()
+	if len(replay) != 1 || replay[0].Type != "warning" || !strings.Contains(replay[0].Message, ws.WorkDir) {
+		t.Errorf("events = %+v, want a warning about the work folder", replay)
+	}
+	if job.Snapshot().Space != nil {
+		t.Error("an estimate without free space was recorded")
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"math"
+	"time"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// phaseTracker turns ffmpeg progress reports of the units of a phase (episodes or parts) into the
+// job's percent and ETA, weighting every unit by its media duration. Its fields are guarded by the job's mutex
+type phaseTracker struct {
+	job      *Job
+	started  time.Time
+	work     []float64 // media seconds each unit produces, 0 while unknown
+	done     []float64 // media seconds produced so far
+	finished []bool
+	episodes bool // mirror the units into Progress.Episodes
+}
+
+// newPhaseTracker starts tracking a phase of n units. With episodes set, per-episode progress is
+// published for the given files
+func newPhaseTracker(job *Job, files []string, n int, episodes bool) *phaseTracker {
+	t := &phaseTracker{
+		job:      job,
+		started:  time.Now(),
+		work:     make([]float64, n),
+		done:     make([]float64, n),
+		finished: make([]bool, n),
+		episodes: episodes,
+	}
+	if episodes {
+		job.Update(func(j *models.Job) {
+			j.Progress.Episodes = make([]models.EpisodeProgress, n)
+			for i := range j.Progress.Episodes {
+				j.Progress.Episodes[i] = models.EpisodeProgress{Episode: i + 1, File: files[i]}
+			}
+		})
+	}
+	return t
+}
+
+// SetWork records how many media seconds unit i produces
+func (t *phaseTracker) SetWork(i int, seconds float64) {
+	t.job.Update(func(j *models.Job) {
+		t.work[i] = seconds
+		t.recompute(&j.Progress)
+	})
+}
+
+// Reporter returns the ffmpeg progress callback of unit i
+func (t *phaseTracker) Reporter(i int) ffmpeg.ProgressFunc {
+	return func(r ffmpeg.ProgressReport) {
+		t.job.Update(func(j *models.Job) {
+			if t.finished[i] {
+				return
+			}
+			t.done[i] = r.OutTime
+			if t.work[i] > 0 {
+				t.done[i] = math.Min(r.OutTime, t.work[i])
+			}
+			if t.episodes {
+				ep := &j.Progress.Episodes[i]
+				ep.Speed = r.Speed
+				ep.Size = r.Size
+				ep.ETA = 0
+				if r.Speed > 0 {
+					ep.ETA = math.Max(t.work[i]-t.done[i], 0) / r.Speed
+				}
+			}
+			t.recompute(&j.Progress)
+		})
+	}
+}
+
+// Finish marks unit i as done, counting it as completed when ok
+func (t *phaseTracker) Finish(i int, ok bool) {
+	t.job.Update(func(j *models.Job) {
+		t.finished[i] = true
+		if t.work[i] == 0 {
+			// a unit that never got far enough to know its length weighs like an average one
+			t.work[i] = t.averageWork()
+		}
+		t.done[i] = t.work[i]
+		if ok {
+			j.Progress.Completed++
+		}
+		if t.episodes {
+			ep := &j.Progress.Episodes[i]
+			ep.ETA = 0
+		}
+		t.recompute(&j.Progress)
+	})
+}
+
+// averageWork is the mean of the known unit lengths, 1 when none is known yet
+func (t *phaseTracker) averageWork() float64 {
+	sum, n := 0.0, 0
+	for _, w := range t.work {
+		if w > 0 {
+			sum += w
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
+
+// recompute derives the phase and episode percentages and the phase ETA
+func (t *phaseTracker) recompute(p *models.Progress) {
+	avg := t.averageWork()
+	total, done := 0.0, 0.0
+	for i, w := range t.work {
+		if w == 0 {
+			w = avg
+		}
+		d := math.Min(t.done[i], w)
+		total += w
+		done += d
+		if t.episodes {
+			p.Episodes[i].Percent = d / w * 100
+		}
+	}
+	if total == 0 {
+		return
+	}
+	fraction := done / total
+	p.Percent = fraction * 100
+	p.ETA = 0
+	if fraction > 0 && fraction < 1 {
+		// parallel ffmpeg runs share the machine, so the wall-clock rate is the honest estimate
+		elapsed := time.Since(t.started).Seconds()
+		p.ETA = elapsed * (1 - fraction) / fraction
+	}
+}
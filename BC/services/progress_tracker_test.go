@@ -0,0 +1,40 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestPhaseTrackerWeightsByDuration(t *testing.T) {
+	job := NewJobRegistry().Create("in", "out", models.TrimOptions{})
+	tracker := newPhaseTracker(job, []string{"a.mkv", "b.mkv"}, 2, true)
+	tracker.SetWork(0, 300)
+	tracker.SetWork(1, 100)
+
+	tracker.Reporter(0)(ffmpeg.ProgressReport{OutTime: 150, Speed: 50, Size: 1024})
+	p := job.Snapshot().Progress
+	if math.Abs(p.Percent-37.5) > 1e-9 {
+		t.Errorf("percent = %v, want 37.5", p.Percent)
+	}
+	if ep := p.Episodes[0]; ep.Percent != 50 || ep.ETA != 3 || ep.Size != 1024 {
+		t.Errorf("episode 1 = %+v, want 50%% with 3s left", ep)
+	}
+	if p.Episodes[1].Percent != 0 {
+		t.Errorf("episode 2 percent = %v, want 0", p.Episodes[1].Percent)
+	}
+
+	tracker.Finish(1, true)
+	p = job.Snapshot().Progress
+	if math.Abs(p.Percent-62.5) > 1e-9 || p.Completed != 1 {
+		t.Errorf("after finishing episode 2: percent = %v, completed = %d; want 62.5, 1", p.Percent, p.Completed)
+	}
+
+	// reports after an episode finished do not move it back
+	tracker.Reporter(1)(ffmpeg.ProgressReport{OutTime: 10})
+	if p := job.Snapshot().Progress; p.Episodes[1].Percent != 100 {
+		t.Errorf("finished episode percent = %v, want 100", p.Episodes[1].Percent)
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// reuseDurationTolerance is how far (in seconds) a reused trimmed episode may be off its recorded duration
+const reuseDurationTolerance = 0.5
+
+// fingerprintSample is how much of the start and of the end of a source file its fingerprint hashes
+const fingerprintSample = 1 << 20
+
+// episodeFingerprint identifies the trimmed output of an episode: the source file (path, size,
+// modification time and a hash of its first and last MiB) and everything the trim depends on: the
+// resolved keep segments, streams and cut settings
+func episodeFingerprint(file string, keep []models.Segment, streams ffmpeg.StreamPlan, cut ffmpeg.CutSettings) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	sample, err := sampleContent(file, info.Size())
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(struct {
+		Path    string
+		Size    int64
+		ModTime int64
+		Sample  string
+		Keep    []models.Segment
+		Streams ffmpeg.StreamPlan
+		Cut     ffmpeg.CutSettings
+	}{filepath.Clean(file), info.Size(), info.ModTime().UnixNano(), sample, keep, streams, cut})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sampleContent hashes the first and last fingerprintSample bytes of a file of the given size (all of
+// it when smaller), so a source rewritten in place with the same size and time still changes
+func sampleContent(file string, size int64) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, min(size, fingerprintSample)); err != nil {
+		return "", err
+	}
+	if tail := max(size-fingerprintSample, fingerprintSample); tail < size {
+		if _, err := io.Copy(h, io.NewSectionReader(f, tail, size-tail)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reusableEpisode is a succeeded episode of an earlier job and the ID of that job
+type reusableEpisode struct {
+	models.EpisodeStatus
+	Job string
+}
+
+// reusableEpisodes collects the succeeded episodes of finished jobs writing to output whose trimmed
+// files those jobs still hold, keyed by fingerprint; the most recent job wins. Running jobs are
+// skipped, since their cleanup would remove the files from under the job reusing them
+func reusableEpisodes(jobID, output string) map[string]reusableEpisode {
+	reusable := make(map[string]reusableEpisode)
+	for _, j := range Jobs.List() {
+		if j.ID == jobID || !j.Progress.Done || filepath.Clean(j.Output) != filepath.Clean(output) {
+			continue
+		}
+		for _, ep := range j.Episodes {
+			if ep.State == "succeeded" && ep.Fingerprint != "" && slices.Contains(j.Intermediates, ep.Output) {
+				reusable[ep.Fingerprint] = reusableEpisode{EpisodeStatus: ep, Job: j.ID}
+			}
+		}
+	}
+	return reusable
+}
+
+// claimReusable takes ownership of the files of a reusable episode by removing them from the
+// intermediates of the job that trimmed it. It returns false when that job no longer holds them,
+// e.g. because another job claimed them first
+func claimReusable(ep reusableEpisode) bool {
+	old, ok := Jobs.Get(ep.Job)
+	if !ok {
+		return false
+	}
+	claimed := false
+	old.Update(func(j *models.Job) {
+		if !slices.Contains(j.Intermediates, ep.Output) {
+			return
+		}
+		claimed = true
+		j.Intermediates = slices.DeleteFunc(j.Intermediates, func(p string) bool {
+			return p == ep.Output || (ep.Metadata != "" && p == ep.Metadata)
+		})
+	})
+	return claimed
+}
+
+// validateReusable checks that a recorded trimmed episode is still on disk and complete
+func validateReusable(ctx context.Context, ep models.EpisodeStatus) error {
+	info, err := os.Stat(ep.Output)
+	if err != nil {
+		return err
+	}
+	if info.Size() != ep.Size {
+		return fmt.Errorf("size is %d bytes, recorded %d", info.Size(), ep.Size)
+	}
+	if ep.Metadata != "" {
+		if _, err := os.Stat(ep.Metadata); err != nil {
+			return err
+		}
+	}
+	dur, err := ffmpeg.GetDuration(ctx, ep.Output)
+	if err != nil {
+		return err
+	}
+	if math.Abs(dur-ep.Duration) > reuseDurationTolerance {
+		return fmt.Errorf("duration is %.3fs, recorded %.3fs", dur, ep.Duration)
+	}
+	return nil
+}
+
+// resumeFiles returns the trimmed episodes (and their metadata) of the succeeded episodes, which
+// are kept on disk so a later job can resume from them
+func resumeFiles(episodes []models.EpisodeStatus) map[string]bool {
+	files := make(map[string]bool)
+	for _, ep := range episodes {
+		if ep.State != "succeeded" {
+			continue
+		}
+		files[filepath.Clean(ep.Output)] = true
+		if ep.Metadata != "" {
+			files[filepath.Clean(ep.Metadata)] = true
+		}
+	}
+	return files
+}
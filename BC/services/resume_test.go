@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestEpisodeFingerprint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "Episode 1.mkv")
+	if err := os.WriteFile(file, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keep := []models.Segment{{Start: 0, End: 90}, {Start: 180, End: 1420}}
+	streams := ffmpeg.StreamPlan{Audio: ffmpeg.AudioPlan{Tracks: []int{0, 1}}}
+	copyCut := ffmpeg.CutSettings{Mode: ffmpeg.CutCopy}
+
+	base, err := episodeFingerprint(file, keep, streams, copyCut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := episodeFingerprint(file, keep, streams, copyCut); again != base {
+		t.Error("fingerprint is not stable")
+	}
+
+	otherKeep := []models.Segment{{Start: 0, End: 95}, {Start: 180, End: 1420}}
+	if fp, _ := episodeFingerprint(file, otherKeep, streams, copyCut); fp == base {
+		t.Error("different keep segments must change the fingerprint")
+	}
+	otherStreams := ffmpeg.StreamPlan{Audio: ffmpeg.AudioPlan{Tracks: []int{1}}}
+	if fp, _ := episodeFingerprint(file, keep, otherStreams, copyCut); fp == base {
+		t.Error("different streams must change the fingerprint")
+	}
+
+	if fp, _ := episodeFingerprint(file, keep, streams, ffmpeg.CutSettings{Mode: ffmpeg.CutSmart}); fp == base {
+		t.Error("a different cut mode must change the fingerprint")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fp, _ := episodeFingerprint(file, keep, streams, copyCut); fp == base {
+		t.Error("a modified source file must change the fingerprint")
+	}
+
+	// rewritten in place with the same size and the modification time restored
+	touched, _ := episodeFingerprint(file, keep, streams, copyCut)
+	if err := os.WriteFile(file, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fp, _ := episodeFingerprint(file, keep, streams, copyCut); fp == touched {
+		t.Error("a rewritten source file with the same size and time must change the fingerprint")
+	}
+
+	// sources larger than two samples are told apart by their last MiB
+	big := make([]byte, 3*fingerprintSample)
+	var fps []string
+	for _, last := range []byte{0, 1} {
+		big[len(big)-1] = last
+		if err := os.WriteFile(file, big, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+		fp, err := episodeFingerprint(file, keep, streams, copyCut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fps = append(fps, fp)
+	}
+	if fps[0] == fps[1] {
+		t.Error("a change in the last MiB must change the fingerprint")
+	}
+
+	if _, err := episodeFingerprint(filepath.Join(t.TempDir(), "missing.mkv"), keep, streams, copyCut); err == nil {
+		t.Error("expected an error for a missing source file")
+	}
+}
+
+func TestValidateReusableRejectsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "Episode 1_seg_0_1300.mkv")
+	if err := os.WriteFile(out, []byte("trimmed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ep := models.EpisodeStatus{State: "succeeded", Output: out, Size: 100, Duration: 1300}
+	if err := validateReusable(context.Background(), ep); err == nil || !strings.Contains(err.Error(), "size") {
+		t.Errorf("size mismatch: got %v", err)
+	}
+
+	ep.Size = int64(len("trimmed"))
+	ep.Metadata = filepath.Join(dir, "Episode 1_meta_1.txt")
+	if err := validateReusable(context.Background(), ep); !os.IsNotExist(err) {
+		t.Errorf("missing metadata: got %v", err)
+	}
+
+	ep.Output = filepath.Join(dir, "gone.mkv")
+	if err := validateReusable(context.Background(), ep); !os.IsNotExist(err) {
+		t.Errorf("missing output: got %v", err)
+	}
+}
+
+func TestResumeFiles(t *testing.T) {
+	files := resumeFiles([]models.EpisodeStatus{
+		{State: "succeeded", Output: "/out/a_seg_0_10.mkv", Metadata: "/out/a_meta_1.txt"},
+		{State: "succeeded", Output: "/out/merged_b_2.mkv"},
+		{State: "failed", Output: "/out/c_seg_0_10.mkv"},
+	})
+	want := []string{"/out/a_seg_0_10.mkv", "/out/a_meta_1.txt", "/out/merged_b_2.mkv"}
+	if len(files) != len(want) {
+		t.Fatalf("got %v, want %v", files, want)
+	}
+	for _, f := range want {
+		if !files[filepath.Clean(f)] {
+			t.Errorf("%s should be kept", f)
+		}
+	}
+}
+
+func TestReusableEpisodesOnlyFromFinishedJobs(t *testing.T) {
+	output := t.TempDir()
+	trimmed := func(job *Job, name string) models.EpisodeStatus {
+		ep := models.EpisodeStatus{Episode: 1, State: "succeeded", Output: filepath.Join(output, name), Fingerprint: name}
+		job.Update(func(j *models.Job) { j.Episodes = []models.EpisodeStatus{ep} })
+		job.Track(ep.Output)
+		return ep
+	}
+
+	finished := Jobs.Create("in", output, models.TrimOptions{})
+	done := trimmed(finished, "done.mkv")
+	finished.Finish(func(p *models.Progress) { p.Status = "partial" })
+	running := Jobs.Create("in", output, models.TrimOptions{})
+	trimmed(running, "running.mkv")
+	resumed := Jobs.Create("in", output, models.TrimOptions{})
+
+	reusable := reusableEpisodes(resumed.ID(), output)
+	if len(reusable) != 1 || reusable["done.mkv"].Job != finished.ID() {
+		t.Fatalf("reusable = %+v, want only the episode of the finished job", reusable)
+	}
+
+	// the first job to claim the episode owns it; the trimming job no longer removes it
+	if !claimReusable(reusable["done.mkv"]) {
+		t.Fatal("claiming a held episode failed")
+	}
+	if slices.Contains(finished.Snapshot().Intermediates, done.Output) {
+		t.Error("the claimed episode is still an intermediate of the job that trimmed it")
+	}
+	if claimReusable(reusable["done.mkv"]) {
+		t.Error("an episode was claimed twice")
+	}
+	if reusable := reusableEpisodes(resumed.ID(), output); len(reusable) != 0 {
+		t.Errorf("claimed episode is still reusable: %+v", reusable)
+	}
+}
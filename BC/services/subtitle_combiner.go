@@ -1,165 +1,213 @@
 package services
 
-// import (
-// 	"fmt"
-// 	"log"
-// 	"os"
-// 	"path/filepath"
-// 	"sort"
-// 	"strings"
-
-// 	"github.com/sanke08/videoprocessor/utils"
-// )
-
-// CombineSubtitles combines subtitle files from multiple episodes with proper timing offsets
-// func CombineSubtitles(episodeSubsDir string, durations []float64, outputDir string, partNum int) error {
-// 	subsDir := filepath.Join(episodeSubsDir, "subtitles")
-// 	if _, err := os.Stat(subsDir); os.IsNotExist(err) {
-// 		log.Printf("No subtitles directory found, skipping subtitle combination")
-// 		return nil
-// 	}
-
-// 	// Find all subtitle files
-// 	files, err := filepath.Glob(filepath.Join(subsDir, "*.srt"))
-// 	if err != nil || len(files) == 0 {
-// 		log.Printf("No subtitle files found to combine")
-// 		return nil
-// 	}
-
-// 	// Group subtitle files by language/track
-// 	trackGroups := make(map[string][]string)
-// 	for _, file := range files {
-// 		base := filepath.Base(file)
-// 		// Extract language from filename (format: episodeName_lang_trackIndex.srt)
-// 		parts := strings.Split(base, "_")
-// 		if len(parts) >= 2 {
-// 			lang := parts[len(parts)-2] // Get language
-// 			trackGroups[lang] = append(trackGroups[lang], file)
-// 		}
-// 	}
-
-// 	// Create output subtitles directory
-// 	outSubsDir := filepath.Join(outputDir, "subtitles")
-// 	os.MkdirAll(outSubsDir, 0755)
-
-// 	// Combine each track group
-// 	for lang, trackFiles := range trackGroups {
-// 		sort.Slice(trackFiles, func(i, j int) bool {
-// 			return utils.NaturalLess(trackFiles[i], trackFiles[j])
-// 		})
-
-// 		combinedFile := filepath.Join(outSubsDir, fmt.Sprintf("Part%d_%s.srt", partNum, lang))
-
-// 		err := combineSRTFiles(trackFiles, combinedFile, durations)
-// 		if err != nil {
-// 			log.Printf("⚠️ Failed to combine %s subtitles: %v", lang, err)
-// 			continue
-// 		}
-
-// 		log.Printf("✅ Combined subtitle track: Part%d_%s.srt", partNum, lang)
-// 	}
-
-// 	return nil
-// }
-
-// func combineSRTFiles(files []string, outputFile string, durations []float64) error {
-// 	var allContent strings.Builder
-// 	currentOffset := 0.0
-// 	subtitleIndex := 1
-
-// 	for i, file := range files {
-// 		content, err := os.ReadFile(file)
-// 		if err != nil {
-// 			log.Printf("⚠️ Failed to read %s: %v", file, err)
-// 			continue
-// 		}
-
-// 		lines := strings.Split(string(content), "\n")
-
-// 		for j := 0; j < len(lines); j++ {
-// 			line := strings.TrimSpace(lines[j])
-
-// 			// Skip empty lines between subtitles at the start
-// 			if line == "" && allContent.Len() == 0 {
-// 				continue
-// 			}
-
-// 			// Renumber subtitle indices
-// 			if isSubtitleIndex(line) {
-// 				allContent.WriteString(fmt.Sprintf("%d\n", subtitleIndex))
-// 				subtitleIndex++
-// 				continue
-// 			}
-
-// 			// Adjust timestamps
-// 			if strings.Contains(line, " --> ") {
-// 				parts := strings.Split(line, " --> ")
-// 				if len(parts) == 2 {
-// 					start := shiftSRTTimestampStr(strings.TrimSpace(parts[0]), currentOffset)
-// 					end := shiftSRTTimestampStr(strings.TrimSpace(parts[1]), currentOffset)
-// 					allContent.WriteString(fmt.Sprintf("%s --> %s\n", start, end))
-// 					continue
-// 				}
-// 			}
-
-// 			// Regular line (subtitle text)
-// 			allContent.WriteString(line + "\n")
-// 		}
-
-// 		// Add offset for next episode
-// 		if i < len(durations) {
-// 			currentOffset += durations[i]
-// 		}
-
-// 		// Add blank line between episodes
-// 		allContent.WriteString("\n")
-// 	}
-
-// 	return os.WriteFile(outputFile, []byte(allContent.String()), 0644)
-// }
-
-// func isSubtitleIndex(line string) bool {
-// 	// Check if line is just a number (subtitle index)
-// 	if line == "" {
-// 		return false
-// 	}
-// 	for _, c := range line {
-// 		if c < '0' || c > '9' {
-// 			return false
-// 		}
-// 	}
-// 	return true
-// }
-
-// func shiftSRTTimestampStr(timestamp string, offset float64) string {
-// 	// Parse and shift SRT timestamp
-// 	parts := strings.Split(timestamp, ",")
-// 	if len(parts) != 2 {
-// 		return timestamp
-// 	}
-
-// 	timeParts := strings.Split(parts[0], ":")
-// 	if len(timeParts) != 3 {
-// 		return timestamp
-// 	}
-
-// 	var hours, minutes, seconds, millis int
-// 	fmt.Sscanf(timeParts[0], "%d", &hours)
-// 	fmt.Sscanf(timeParts[1], "%d", &minutes)
-// 	fmt.Sscanf(timeParts[2], "%d", &seconds)
-// 	fmt.Sscanf(parts[1], "%d", &millis)
-
-// 	totalSeconds := float64(hours*3600+minutes*60+seconds) + float64(millis)/1000.0
-// 	totalSeconds += offset
-
-// 	if totalSeconds < 0 {
-// 		totalSeconds = 0
-// 	}
-
-// 	newHours := int(totalSeconds) / 3600
-// 	newMinutes := (int(totalSeconds) % 3600) / 60
-// 	newSeconds := int(totalSeconds) % 60
-// 	newMillis := int((totalSeconds - float64(int(totalSeconds))) * 1000)
-
-// 	return fmt.Sprintf("%02d:%02d:%02d,%03d", newHours, newMinutes, newSeconds, newMillis)
-// }
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// EpisodeSubtitle is one text subtitle track of an episode, retimed to the trimmed episode
+type EpisodeSubtitle struct {
+	Key    string // language plus occurrence within that language, e.g. "eng" or "eng_2"
+	Format string // "srt" or "ass"
+	Header string // ASS header, empty for SRT
+	Cues   []ffmpeg.SubtitleCue
+}
+
+// ExtractEpisodeSubtitles extracts the selected text subtitle tracks of a source episode, drops the cues
+// outside the trimmed segments and shifts the rest so they line up with the trimmed episode. keep are the
+// segments as cut, e.g. starting at keyframes for stream copy.
+// Bitmap tracks cannot be exported as text and are skipped
+func ExtractEpisodeSubtitles(ctx context.Context, file, workDir string, keep []models.Segment, sel models.SubtitleSelection) ([]EpisodeSubtitle, error) {
+	tracks, err := ffmpeg.ScanSubtitles(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(sel.Mode, "none") || sel.Mode == "" {
+		sel.Mode = "all"
+	}
+	plan, err := ffmpeg.ResolveSubtitlePlan(tracks, sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Tracks) == 0 {
+		return nil, nil
+	}
+
+	tempDir, err := os.MkdirTemp(workDir, "tmp_subs_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	seen := make(map[string]int)
+	var subs []EpisodeSubtitle
+	for _, pos := range plan.Tracks {
+		track := tracks[pos]
+		ext := ".srt"
+		switch track.Codec {
+		case "ass", "ssa":
+			ext = ".ass"
+		case "hdmv_pgs_subtitle", "dvd_subtitle", "dvb_subtitle", "xsub":
+			log.Printf("⚠️ Skipping bitmap subtitle track %d (%s) of %s", track.Index, track.Codec, filepath.Base(file))
+			continue
+		}
+
+		lang := track.Language
+		if lang == "" {
+			lang = "und"
+		}
+		seen[lang]++
+		key := lang
+		if seen[lang] > 1 {
+			key = fmt.Sprintf("%s_%d", lang, seen[lang])
+		}
+
+		subFile := filepath.Join(tempDir, fmt.Sprintf("track%d%s", track.Index, ext))
+		if err := ffmpeg.ExtractSubtitle(ctx, file, track.Index, subFile); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("⚠️ Failed to extract subtitle track %d of %s: %v", track.Index, filepath.Base(file), err)
+			continue
+		}
+		doc, err := ffmpeg.ReadSubtitleFile(subFile)
+		if err != nil {
+			log.Printf("⚠️ Failed to parse subtitle track %d of %s: %v", track.Index, filepath.Base(file), err)
+			continue
+		}
+		subs = append(subs, EpisodeSubtitle{
+			Key:    key,
+			Format: doc.Format,
+			Header: doc.Header,
+			Cues:   RetimeCues(doc.Cues, keep),
+		})
+	}
+	return subs, nil
+}
+
+// RetimeCues maps cues from source time onto the concatenation of the keep segments.
+// Cues inside skipped ranges are dropped, cues straddling a cut are clipped to the kept side,
+// and a cue spanning a whole skipped range stays one cue joined across the cut
+func RetimeCues(cues []ffmpeg.SubtitleCue, keep []models.Segment) []ffmpeg.SubtitleCue {
+	const joinEpsilon = 0.001
+	out := []ffmpeg.SubtitleCue{}
+	for _, c := range cues {
+		last := -1
+		pos := 0.0
+		for _, seg := range keep {
+			s := max(c.Start, seg.Start)
+			e := min(c.End, seg.End)
+			if e > s {
+				shifted := c
+				shifted.Start = pos + s - seg.Start
+				shifted.End = pos + e - seg.Start
+				if last >= 0 && shifted.Start-out[last].End < joinEpsilon {
+					out[last].End = shifted.End
+				} else {
+					out = append(out, shifted)
+					last = len(out) - 1
+				}
+			}
+			pos += seg.End - seg.Start
+		}
+	}
+	return out
+}
+
+// copyCutLookback is how far (in seconds) before a cut the keyframe a stream-copy trim starts at is searched
+const copyCutLookback = 20.0
+
+// copyCutSegments returns the segments a stream-copy trim of file actually writes: the seek before the
+// input starts each one at the last keyframe at or before its planned start. Segments whose keyframes
+// cannot be read are kept as planned
+func copyCutSegments(ctx context.Context, file string, keep []models.Segment) []models.Segment {
+	cuts := make([]models.Segment, len(keep))
+	for i, seg := range keep {
+		cuts[i] = seg
+		if keyframes, err := ffmpeg.Keyframes(ctx, file, max(seg.Start-copyCutLookback, 0), seg.Start); err == nil {
+			cuts[i] = snapSegmentStart(seg, keyframes)
+		}
+	}
+	return cuts
+}
+
+// snapSegmentStart moves the start of seg back to the last of keyframes at or before it
+func snapSegmentStart(seg models.Segment, keyframes []float64) models.Segment {
+	const epsilon = 0.001
+	start := -1.0
+	for _, k := range keyframes {
+		if k <= seg.Start+epsilon && k > start {
+			start = k
+		}
+	}
+	if start >= 0 {
+		seg.Start = min(start, seg.Start)
+	}
+	return seg
+}
+
+// CombineSubtitles joins the retimed subtitle tracks of a part's episodes, offsetting each episode by
+// the durations of the ones before it, and writes subtitles/<partName>_<lang>.srt|.ass into outputDir.
+// It returns the written files
+func CombineSubtitles(episodeSubs [][]EpisodeSubtitle, durations []float64, outputDir string, partName string) ([]string, error) {
+	type combined struct {
+		doc   ffmpeg.SubtitleDoc
+		order int
+	}
+	tracks := make(map[string]*combined)
+	offset := 0.0
+	for i, subs := range episodeSubs {
+		for _, sub := range subs {
+			c, ok := tracks[sub.Key]
+			if !ok {
+				// the first episode carrying a track provides its format and ASS styles
+				c = &combined{doc: ffmpeg.SubtitleDoc{Format: sub.Format, Header: sub.Header}, order: len(tracks)}
+				tracks[sub.Key] = c
+			}
+			for _, cue := range sub.Cues {
+				cue.Start += offset
+				cue.End += offset
+				c.doc.Cues = append(c.doc.Cues, cue)
+			}
+		}
+		if i < len(durations) {
+			offset += durations[i]
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, nil
+	}
+
+	subsDir := filepath.Join(outputDir, "subtitles")
+	if err := os.MkdirAll(subsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(tracks))
+	for key := range tracks {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return tracks[keys[i]].order < tracks[keys[j]].order })
+
+	var written []string
+	for _, key := range keys {
+		c := tracks[key]
+		sort.SliceStable(c.doc.Cues, func(i, j int) bool { return c.doc.Cues[i].Start < c.doc.Cues[j].Start })
+		subFile := filepath.Join(subsDir, fmt.Sprintf("%s_%s.%s", partName, key, c.doc.Format))
+		if err := ffmpeg.WriteSubtitleFile(subFile, &c.doc); err != nil {
+			log.Printf("⚠️ Failed to write %s: %v", filepath.Base(subFile), err)
+			continue
+		}
+		log.Printf("✅ Combined subtitle track: %s", filepath.Base(subFile))
+		written = append(written, subFile)
+	}
+	return written, nil
+}
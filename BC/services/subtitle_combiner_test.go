@@ -0,0 +1,151 @@
+package services
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func cue(start, end float64, text string) ffmpeg.SubtitleCue {
+	return ffmpeg.SubtitleCue{Start: start, End: end, Text: text}
+}
+
+func assertCues(t *testing.T, got, want []ffmpeg.SubtitleCue) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d cues %+v, want %d %+v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if math.Abs(got[i].Start-want[i].Start) > 1e-9 || math.Abs(got[i].End-want[i].End) > 1e-9 || got[i].Text != want[i].Text {
+			t.Errorf("cue %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// keep [0,10) and [40,100): the range [10,40) is skipped, so source 40 maps to 10
+var testKeep = []models.Segment{{Start: 0, End: 10}, {Start: 40, End: 100}}
+
+func TestRetimeCuesDropsSkipped(t *testing.T) {
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(2, 4, "a"), cue(15, 20, "skipped"), cue(50, 52, "b")}, testKeep)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(2, 4, "a"), cue(20, 22, "b")})
+}
+
+func TestRetimeCuesStraddlingCutStart(t *testing.T) {
+	// starts before the skipped range and ends inside it: clipped at the cut
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(8, 12, "x")}, testKeep)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(8, 10, "x")})
+}
+
+func TestRetimeCuesStraddlingCutEnd(t *testing.T) {
+	// starts inside the skipped range and ends after it: starts right at the cut
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(38, 43, "x")}, testKeep)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(10, 13, "x")})
+}
+
+func TestRetimeCuesSpanningSkippedRange(t *testing.T) {
+	// covers the whole skipped range: both kept sides are joined into one cue
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(9, 41, "x")}, testKeep)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(9, 11, "x")})
+}
+
+func TestRetimeCuesPastEnd(t *testing.T) {
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(99, 105, "x"), cue(120, 121, "gone")}, testKeep)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(69, 70, "x")})
+}
+
+func TestRetimeCuesNoSkips(t *testing.T) {
+	cues := []ffmpeg.SubtitleCue{cue(1, 2, "a"), cue(3, 4, "b")}
+	assertCues(t, RetimeCues(cues, []models.Segment{{Start: 0, End: 10}}), cues)
+}
+
+func TestRetimeCuesSnappedSegmentStart(t *testing.T) {
+	// stream copy starts the second segment at the keyframe at 40s instead of at 41.5s
+	planned := []models.Segment{{Start: 0, End: 10}, {Start: 41.5, End: 100}}
+	keyframes := []float64{36, 38, 40, 42}
+	cuts := []models.Segment{snapSegmentStart(planned[0], []float64{0, 2}), snapSegmentStart(planned[1], keyframes)}
+	if cuts[1].Start != 40 || cuts[0].Start != 0 {
+		t.Fatalf("snapped segments = %+v, want the second starting at 40", cuts)
+	}
+
+	// the keyframe slack is part of the trimmed episode, so later cues shift by it instead of drifting
+	got := RetimeCues([]ffmpeg.SubtitleCue{cue(2, 4, "a"), cue(40.5, 41, "slack"), cue(50, 52, "b")}, cuts)
+	assertCues(t, got, []ffmpeg.SubtitleCue{cue(2, 4, "a"), cue(10.5, 11, "slack"), cue(20, 22, "b")})
+
+	// a start on a keyframe or without a keyframe before it stays as planned
+	if seg := snapSegmentStart(models.Segment{Start: 42, End: 50}, keyframes); seg.Start != 42 {
+		t.Errorf("start on a keyframe moved to %v", seg.Start)
+	}
+	if seg := snapSegmentStart(models.Segment{Start: 30, End: 50}, keyframes); seg.Start != 30 {
+		t.Errorf("start without an earlier keyframe moved to %v", seg.Start)
+	}
+}
+
+func TestCombineSubtitlesOffsetsEpisodes(t *testing.T) {
+	dir := t.TempDir()
+	episodeSubs := [][]EpisodeSubtitle{
+		{{Key: "eng", Format: "srt", Cues: []ffmpeg.SubtitleCue{cue(1, 2, "ep1")}}},
+		{}, // episode without subtitles still advances the offset
+		{
+			{Key: "eng", Format: "srt", Cues: []ffmpeg.SubtitleCue{cue(0.5, 1.5, "ep3")}},
+			{Key: "jpn", Format: "srt", Cues: []ffmpeg.SubtitleCue{cue(3, 4, "ep3 jpn")}},
+		},
+	}
+	written, err := CombineSubtitles(episodeSubs, []float64{60, 30, 45}, dir, "Part2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 || filepath.Base(written[0]) != "Part2_eng.srt" || filepath.Base(written[1]) != "Part2_jpn.srt" {
+		t.Fatalf("written = %v", written)
+	}
+
+	doc, err := ffmpeg.ReadSubtitleFile(filepath.Join(dir, "subtitles", "Part2_eng.srt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCues(t, doc.Cues, []ffmpeg.SubtitleCue{cue(1, 2, "ep1"), cue(90.5, 91.5, "ep3")})
+
+	doc, err = ffmpeg.ReadSubtitleFile(filepath.Join(dir, "subtitles", "Part2_jpn.srt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCues(t, doc.Cues, []ffmpeg.SubtitleCue{cue(93, 94, "ep3 jpn")})
+}
+
+func TestCombineSubtitlesKeepsASSHeader(t *testing.T) {
+	dir := t.TempDir()
+	header := "[Script Info]\nScriptType: v4.00+\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n"
+	episodeSubs := [][]EpisodeSubtitle{
+		{{Key: "eng", Format: "ass", Header: header, Cues: []ffmpeg.SubtitleCue{{Start: 1, End: 2, Layer: "0", Text: "Default,,0,0,0,,Hi"}}}},
+		{{Key: "eng", Format: "ass", Header: header, Cues: []ffmpeg.SubtitleCue{{Start: 1, End: 2, Layer: "0", Text: "Default,,0,0,0,,Again"}}}},
+	}
+	if _, err := CombineSubtitles(episodeSubs, []float64{10, 10}, dir, "Part1"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "subtitles", "Part1_eng.ass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(content)
+	if strings.Count(text, "[Script Info]") != 1 {
+		t.Errorf("header should appear once:\n%s", text)
+	}
+	if !strings.Contains(text, "Dialogue: 0,0:00:11.00,0:00:12.00,Default,,0,0,0,,Again") {
+		t.Errorf("second episode cue not offset:\n%s", text)
+	}
+}
+
+func TestCombineSubtitlesNothingToWrite(t *testing.T) {
+	dir := t.TempDir()
+	written, err := CombineSubtitles([][]EpisodeSubtitle{nil, nil}, []float64{1, 1}, dir, "Part1")
+	if err != nil || len(written) != 0 {
+		t.Fatalf("written = %v, err = %v", written, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "subtitles")); !os.IsNotExist(err) {
+		t.Error("subtitles dir should not be created")
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+// DefaultVerifyTolerance is the duration difference allowed between a part and its episodes, in seconds
+const DefaultVerifyTolerance = 1.0
+
+// VerifySettings is the resolved post-merge verification of a request
+type VerifySettings struct {
+	Tolerance float64
+	Decode    bool
+}
+
+// ResolveVerify validates the verification options of a request
+func ResolveVerify(opts models.TrimOptions) (VerifySettings, error) {
+	v := VerifySettings{Tolerance: DefaultVerifyTolerance, Decode: opts.Verify.Decode}
+	if opts.Verify.Tolerance != "" {
+		tolerance, err := ffmpeg.ParseTimestamp(opts.Verify.Tolerance)
+		if err != nil {
+			return VerifySettings{}, fmt.Errorf("invalid verify tolerance %q: %v", opts.Verify.Tolerance, err)
+		}
+		if tolerance < 0 {
+			return VerifySettings{}, fmt.Errorf("verify tolerance must not be negative")
+		}
+		v.Tolerance = tolerance
+	}
+	return v, nil
+}
+
+// partExpectation describes what a merged part should contain: the streams of its reference episode
+// (without subtitles when joined with the concat filter), the summed episode durations and the chapters
+// of the combined chapter file (empty when none was applied)
+func partExpectation(ref []ffmpeg.StreamParams, filter bool, durations []float64, chaptersFile string) *models.PartVerification {
+	v := &models.PartVerification{ExpectedStreams: map[string]int{}, ExpectedDuration: sum(durations)}
+	for _, st := range ref {
+		if filter && st.Type == "subtitle" {
+			continue
+		}
+		v.ExpectedStreams[st.Type]++
+	}
+	if chaptersFile != "" {
+		if mf, err := ffmpeg.ParseFFMetadata(chaptersFile); err == nil {
+			v.ExpectedChapters = len(mf.Chapters)
+		}
+	}
+	return v
+}
+
+// compareMedia fills in what ffprobe found in a part and lists how it differs from the expectation
+func compareMedia(v *models.PartVerification, info ffmpeg.MediaInfo, tolerance float64) {
+	v.Streams = info.Streams
+	v.Duration = info.Duration
+	v.Chapters = info.Chapters
+	v.Problems = nil
+	for _, kind := range []string{"video", "audio", "subtitle"} {
+		if got, want := info.Streams[kind], v.ExpectedStreams[kind]; got != want {
+			v.Problems = append(v.Problems, fmt.Sprintf("%d %s stream(s), expected %d", got, kind, want))
+		}
+	}
+	if diff := info.Duration - v.ExpectedDuration; math.Abs(diff) > tolerance {
+		v.Problems = append(v.Problems, fmt.Sprintf("duration %.3fs, expected %.3fs (%+.3fs)", info.Duration, v.ExpectedDuration, diff))
+	}
+	if info.Chapters != v.ExpectedChapters {
+		v.Problems = append(v.Problems, fmt.Sprintf("%d chapter(s), expected %d", info.Chapters, v.ExpectedChapters))
+	}
+	v.Passed = len(v.Problems) == 0
+}
+
+// VerifyParts probes every merged part of the job against the expectation recorded while merging it
+// and, with Decode set, decodes it fully. Results are stored in the part records; the returned error
+// lists the parts that failed
+func VerifyParts(ctx context.Context, job *Job, settings VerifySettings) error {
+	var parts []models.PartStatus
+	for _, p := range job.Snapshot().Parts {
+		if p.Output != "" && p.Verification != nil {
+			parts = append(parts, p)
+		}
+	}
+	tracker := newPhaseTracker(job, nil, len(parts), false)
+	for i, p := range parts {
+		tracker.SetWork(i, p.Verification.ExpectedDuration)
+	}
+
+	var failed []string
+	for i, p := range parts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		v := p.Verification
+		info, err := ffmpeg.ProbeMedia(ctx, p.Output)
+		if err != nil {
+			v.Problems, v.Passed = []string{err.Error()}, false
+		} else {
+			compareMedia(v, info, settings.Tolerance)
+		}
+		if settings.Decode && err == nil {
+			job.Logf("🔍 Decoding part %d to check for corruption...", p.Part)
+			decodeCtx, cancel := context.WithTimeout(ctx, 3*time.Hour)
+			err := ffmpeg.DecodeCheck(decodeCtx, p.Output, tracker.Reporter(i))
+			cancel()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			v.Decoded = err == nil
+			if err != nil {
+				v.Problems = append(v.Problems, err.Error())
+				v.Passed = false
+			}
+		}
+		job.UpdatePart(p.Part, func(ps *models.PartStatus) { ps.Verification = v })
+		if v.Passed {
+			job.Logf("✅ Part %d verified", p.Part)
+		} else {
+			job.Logf("⚠️ Part %d failed verification: %s", p.Part, strings.Join(v.Problems, "; "))
+			failed = append(failed, strconv.Itoa(p.Part))
+		}
+		tracker.Finish(i, v.Passed)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("part(s) %s failed verification, see the part verification", strings.Join(failed, ", "))
+	}
+	return nil
+}
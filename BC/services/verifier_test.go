@@ -0,0 +1,64 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestResolveVerify(t *testing.T) {
+	v, err := ResolveVerify(models.TrimOptions{})
+	if err != nil || v.Tolerance != DefaultVerifyTolerance || v.Decode {
+		t.Errorf("default = %+v, %v", v, err)
+	}
+	v, err = ResolveVerify(models.TrimOptions{Verify: models.VerifyOptions{Tolerance: "2.5", Decode: true}})
+	if err != nil || v.Tolerance != 2.5 || !v.Decode {
+		t.Errorf("tolerance 2.5 = %+v, %v", v, err)
+	}
+	if _, err := ResolveVerify(models.TrimOptions{Verify: models.VerifyOptions{Tolerance: "soon"}}); err == nil {
+		t.Error("invalid tolerance accepted")
+	}
+}
+
+func TestPartExpectation(t *testing.T) {
+	chapters := filepath.Join(t.TempDir(), "chapters.txt")
+	meta := ";FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1000\ntitle=A\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=1000\nEND=2000\ntitle=B\n"
+	if err := os.WriteFile(chapters, []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ref := []ffmpeg.StreamParams{{Type: "video"}, {Type: "audio"}, {Type: "audio"}, {Type: "subtitle"}}
+
+	v := partExpectation(ref, false, []float64{600, 620.5}, chapters)
+	if v.ExpectedDuration != 1220.5 || v.ExpectedChapters != 2 {
+		t.Errorf("expectation = %+v", v)
+	}
+	if v.ExpectedStreams["video"] != 1 || v.ExpectedStreams["audio"] != 2 || v.ExpectedStreams["subtitle"] != 1 {
+		t.Errorf("expected streams = %v", v.ExpectedStreams)
+	}
+	// the concat filter drops subtitles
+	if v := partExpectation(ref, true, nil, ""); v.ExpectedStreams["subtitle"] != 0 || v.ExpectedChapters != 0 {
+		t.Errorf("filter expectation = %+v", v)
+	}
+}
+
+func TestCompareMedia(t *testing.T) {
+	expect := func() *models.PartVerification {
+		return &models.PartVerification{ExpectedStreams: map[string]int{"video": 1, "audio": 2}, ExpectedDuration: 1200, ExpectedChapters: 6}
+	}
+	good := ffmpeg.MediaInfo{Duration: 1200.4, Streams: map[string]int{"video": 1, "audio": 2, "attachment": 3}, Chapters: 6}
+	v := expect()
+	compareMedia(v, good, 1)
+	if !v.Passed || len(v.Problems) != 0 {
+		t.Errorf("good part: %+v", v)
+	}
+
+	bad := ffmpeg.MediaInfo{Duration: 900, Streams: map[string]int{"video": 1, "audio": 1}, Chapters: 4}
+	v = expect()
+	compareMedia(v, bad, 1)
+	if v.Passed || len(v.Problems) != 3 {
+		t.Errorf("bad part: %+v", v)
+	}
+}
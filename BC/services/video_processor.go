@@ -1,11 +1,10 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
-	"sort"
+	"strings"
 	"sync"
 
 	"github.com/sanke08/videoprocessor/ffmpeg"
@@ -13,53 +12,121 @@ import (
 	"github.com/sanke08/videoprocessor/utils"
 )
 
-// ProcessEpisodes is the main orchestrator for processing all episodes
-func ProcessEpisodes(input, output string, opts models.TrimOptions) error {
-	files, _ := filepath.Glob(filepath.Join(input, "*.mkv"))
-	// Use Natural Sort so "Episode 2" comes before "Episode 10"
-	sort.Slice(files, func(i, j int) bool {
-		return utils.NaturalLess(files[i], files[j])
-	})
+// ProcessEpisodes is the main orchestrator for processing all episodes of a job.
+// Cancelling the job kills running ffmpeg processes, removes its intermediates and marks it cancelled
+func ProcessEpisodes(job *Job) error {
+	ctx := job.Context()
+	state := job.Snapshot()
+	input, output, opts := state.Input, state.Output, state.Options
+	// same discovery as /api/scan so both see the identical episode list
+	files, err := utils.ListMKVFiles(input)
+	if err != nil {
+		job.Logf("❌ Failed to list episodes in %s: %v", input, err)
+		failJob(job, err)
+		return err
+	}
 	os.MkdirAll(output, 0755)
+	ws, err := newWorkspace(job, output)
+	if err != nil {
+		job.Logf("❌ Failed to create the work folder in %s: %v", output, err)
+		failJob(job, err)
+		return err
+	}
 
-	models.ProgressState.Update(func(p *models.Progress) {
+	job.Update(func(j *models.Job) {
+		p := &j.Progress
 		p.Total = len(files)
 		p.Completed = 0
 		p.Percent = 0
 		p.Status = "processing"
 		p.Done = false
+		p.Queued = len(files)
+		p.Running = 0
+		j.Episodes = make([]models.EpisodeStatus, len(files))
+		for i, f := range files {
+			j.Episodes[i] = models.EpisodeStatus{Episode: i + 1, Input: f, State: "queued"}
+		}
 	})
 
-	type Result struct {
-		Index    int
-		File     string
-		Meta     string
-		Duration float64
-		Err      error
+	// episodes wait for a slot of this job's own limit first, then for one of the shared pool
+	var jobSlots *WorkerPool
+	workers := Pool.Size()
+	if opts.Concurrency > 0 && opts.Concurrency < Pool.Size() {
+		jobSlots = NewWorkerPool(opts.Concurrency)
+		workers = opts.Concurrency
 	}
 
-	results := make(chan Result, len(files))
+	if err := preflightSpace(ctx, job, files, ws, opts, jobSlots, workers); err != nil {
+		if ctx.Err() != nil {
+			return cancelJob(job, nil)
+		}
+		job.Logf("❌ %v", err)
+		cleanupJob(job, nil)
+		failJob(job, err)
+		return err
+	}
+
+	naming, _ := ResolveNaming(input, opts) // validated with the request
+	var reusable map[string]reusableEpisode
+	if opts.Resume {
+		reusable = reusableEpisodes(job.ID(), output)
+	}
+
+	tracker := newPhaseTracker(job, files, len(files), true)
+	results := make(chan episodeResult, len(files))
 	var wg sync.WaitGroup
 
 	for i, f := range files {
 		wg.Add(1)
 		go func(idx int, file string) {
 			defer wg.Done()
-			log.Printf("▶️ [%02d] Starting -> %s", idx+1, file)
-
-			ch, err := ffmpeg.ScanChapters(file)
+			release, err := acquireWorker(ctx, jobSlots, job.ID())
 			if err != nil {
-				results <- Result{idx, "", "", 0, fmt.Errorf("scan failed: %v", err)}
+				job.Update(func(j *models.Job) {
+					j.Progress.Queued--
+					j.Episodes[idx].State = "cancelled"
+				})
+				tracker.Finish(idx, false)
+				results <- episodeResult{Index: idx, Err: err}
 				return
 			}
+			job.Logf("▶️ [%02d] Starting -> %s", idx+1, file)
+			job.Emit(models.JobEvent{Type: "episode", Episode: idx + 1, File: file, State: "started"})
+			job.Update(func(j *models.Job) {
+				j.Progress.Queued--
+				j.Progress.Running++
+				j.Episodes[idx].State = "processing"
+			})
 
-			finalFile, metaFile, dur, err := ProcessSingleEpisode(file, output, ch, opts)
-			if err != nil {
-				results <- Result{idx, "", "", 0, fmt.Errorf("process failed: %v", err)}
-				return
+			r := processEpisode(ctx, job, tracker, idx, file, ws, opts, naming, reusable)
+			release()
+			job.Update(func(j *models.Job) { j.Progress.Running-- })
+			tracker.Finish(idx, r.Err == nil)
+			switch {
+			case ctx.Err() != nil:
+				job.UpdateEpisode(idx, func(ep *models.EpisodeStatus) { ep.State = "cancelled" })
+			case r.Err != nil:
+				job.UpdateEpisode(idx, func(ep *models.EpisodeStatus) {
+					ep.State = "failed"
+					ep.Error = r.Err.Error()
+				})
+				job.Emit(models.JobEvent{Type: "episode", Episode: idx + 1, File: file, State: "failed", Message: r.Err.Error()})
+			default:
+				var size int64
+				if info, err := os.Stat(r.File); err == nil {
+					size = info.Size()
+				}
+				job.UpdateEpisode(idx, func(ep *models.EpisodeStatus) {
+					ep.State = "succeeded"
+					ep.Output = r.File
+					ep.Metadata = r.Meta
+					ep.Duration = r.Duration
+					ep.Size = size
+					ep.Reused = r.Reused
+				})
+				job.Emit(models.JobEvent{Type: "episode", Episode: idx + 1, File: r.File, State: "finished"})
 			}
-
-			results <- Result{idx, finalFile, metaFile, dur, nil}
+			results <- r
 		}(i, f)
 	}
 
@@ -68,7 +135,7 @@ func ProcessEpisodes(input, output string, opts models.TrimOptions) error {
 		close(results)
 	}()
 
-	allResults := make([]Result, len(files))
+	allResults := make([]episodeResult, len(files))
 	for r := range results {
 		allResults[r.Index] = r
 	}
@@ -76,44 +143,275 @@ func ProcessEpisodes(input, output string, opts models.TrimOptions) error {
 	processedFiles := make([]string, 0, len(files))
 	metaFiles := make([]string, 0, len(files))
 	durations := make([]float64, 0, len(files))
+	subtitles := make([][]EpisodeSubtitle, 0, len(files))
+	var streams *ffmpeg.StreamPlan
+
+	if ctx.Err() != nil {
+		return cancelJob(job, reusedFiles(allResults))
+	}
 
 	for _, r := range allResults {
 		if r.Err != nil {
-			log.Printf("❌ [%02d] Failed: %v", r.Index+1, r.Err)
+			job.Logf("❌ [%02d] Failed: %v", r.Index+1, r.Err)
 			continue
 		}
-		log.Printf("✅ [%02d] Trim success → %s", r.Index+1, r.File)
+		if r.Reused {
+			job.Logf("♻️ [%02d] Reused → %s", r.Index+1, r.File)
+		} else {
+			job.Logf("✅ [%02d] Trim success → %s", r.Index+1, r.File)
+		}
 		processedFiles = append(processedFiles, r.File)
 		metaFiles = append(metaFiles, r.Meta)
 		durations = append(durations, r.Duration)
+		subtitles = append(subtitles, r.Subs)
+		if streams == nil {
+			streams = &allResults[r.Index].Streams
+		} else if len(streams.Audio.Tracks) != len(r.Streams.Audio.Tracks) || len(streams.Subtitles.Tracks) != len(r.Streams.Subtitles.Tracks) {
+			job.Logf("⚠️ [%02d] Keeps %d audio/%d subtitle track(s) but earlier episodes keep %d/%d; merged parts may be inconsistent",
+				r.Index+1, len(r.Streams.Audio.Tracks), len(r.Streams.Subtitles.Tracks), len(streams.Audio.Tracks), len(streams.Subtitles.Tracks))
+		}
+	}
 
-		models.ProgressState.Update(func(p *models.Progress) {
-			p.Completed++
-			if p.Total > 0 {
-				p.Percent = (float64(p.Completed) / float64(p.Total)) * 100
-			}
+	if len(processedFiles) == 0 {
+		err := fmt.Errorf("none of the %d episode(s) could be processed", len(files))
+		if len(files) == 0 {
+			err = fmt.Errorf("no MKV files found in %s", input)
+		}
+		job.Update(func(j *models.Job) {
+			j.Resumable = len(files) > 0
 		})
+		cleanupJob(job, nil)
+		failJob(job, err)
+		return nil
 	}
 
 	// Merge processed files (parts)
-	models.ProgressState.Update(func(p *models.Progress) {
+	grouping, _ := ResolvePartGrouping(opts) // validated with the request
+	job.Update(func(j *models.Job) {
+		p := &j.Progress
 		p.Status = "merging"
 		p.Completed = 0
-		p.Total = opts.Parts
+		p.Total = len(grouping.Groups(durations))
 		p.Percent = 0
+		p.ETA = 0
 	})
 
-	if err := MergeEpisodes(processedFiles, metaFiles, durations, output, opts.Parts); err != nil {
-		log.Println("⚠️ Merge error:", err)
+	if streams == nil {
+		streams = &ffmpeg.StreamPlan{}
+	}
+	if err := MergeEpisodes(ctx, job, processedFiles, metaFiles, durations, subtitles, ws, grouping, *streams); err != nil {
+		if ctx.Err() != nil {
+			return cancelJob(job, reusedFiles(allResults))
+		}
+		job.Logf("⚠️ Merge error: %v", err)
+		job.Update(func(j *models.Job) {
+			j.Error = err.Error()
+		})
+	}
+
+	// check the written parts before reporting the job as done
+	verify, _ := ResolveVerify(opts) // validated with the request
+	job.Update(func(j *models.Job) {
+		p := &j.Progress
+		p.Status = "verifying"
+		p.Completed = 0
+		p.Total = 0
+		for _, part := range j.Parts {
+			if part.Output != "" {
+				p.Total++
+			}
+		}
+		p.Percent = 0
+		p.ETA = 0
+	})
+	if err := VerifyParts(ctx, job, verify); err != nil {
+		if ctx.Err() != nil {
+			return cancelJob(job, reusedFiles(allResults))
+		}
+		job.Update(func(j *models.Job) {
+			j.Error = strings.TrimPrefix(j.Error+"; "+err.Error(), "; ")
+		})
 	}
 
-	models.ProgressState.Update(func(p *models.Progress) {
-		p.Status = "done"
+	final := job.Snapshot()
+	outcome := jobOutcome(final)
+	if outcome == "succeeded" {
+		cleanupJob(job, nil)
+	} else {
+		// keep the trimmed episodes so a resumed job only redoes what failed
+		cleanupJob(job, resumeFiles(final.Episodes))
+		job.Logf("💾 Kept %d trimmed episode(s) for resuming", len(processedFiles))
+		job.Update(func(j *models.Job) {
+			j.Resumable = true
+		})
+	}
+	job.Finish(func(p *models.Progress) {
+		p.Status = outcome
 		p.Percent = 100
-		p.Completed = p.Total
-		p.Done = true
+		p.ETA = 0
 	})
-
-	utils.CleanupTempFolders(output)
 	return nil
 }
+
+// cancelJob removes the job's intermediates except keep (the trimmed episodes it reused from
+// earlier jobs) and marks the job as cancelled
+func cancelJob(job *Job, keep map[string]bool) error {
+	job.Logf("🛑 Job %s cancelled", job.ID())
+	cleanupJob(job, keep)
+	job.Update(func(j *models.Job) {
+		for i := range j.Episodes {
+			if ep := &j.Episodes[i]; ep.State == "queued" || ep.State == "processing" {
+				ep.State = "cancelled"
+			}
+		}
+	})
+	job.Finish(func(p *models.Progress) {
+		p.Status = "cancelled"
+	})
+	return context.Canceled
+}
+
+// acquireWorker waits for a slot of the job's own limit (if any) and then of the shared pool.
+// The returned func releases both
+func acquireWorker(ctx context.Context, jobSlots *WorkerPool, jobID string) (func(), error) {
+	if jobSlots != nil {
+		if err := jobSlots.Acquire(ctx, jobID); err != nil {
+			return nil, err
+		}
+	}
+	if err := Pool.Acquire(ctx, jobID); err != nil {
+		if jobSlots != nil {
+			jobSlots.Release()
+		}
+		return nil, err
+	}
+	return func() {
+		Pool.Release()
+		if jobSlots != nil {
+			jobSlots.Release()
+		}
+	}, nil
+}
+
+// failJob records err on the job and finishes it as failed
+func failJob(job *Job, err error) {
+	job.Update(func(j *models.Job) {
+		j.Error = err.Error()
+	})
+	job.Finish(func(p *models.Progress) {
+		p.Status = "failed"
+		p.ETA = 0
+	})
+}
+
+// jobOutcome classifies a job whose merge has run: "failed" when no part was written,
+// "partial" when an episode or the merge failed, otherwise "succeeded"
+func jobOutcome(j models.Job) string {
+	parts := 0
+	for _, o := range j.Outputs {
+		if o.Kind == "video" {
+			parts++
+		}
+	}
+	if parts == 0 {
+		return "failed"
+	}
+	if j.Error != "" {
+		return "partial"
+	}
+	for _, ep := range j.Episodes {
+		if ep.State != "succeeded" {
+			return "partial"
+		}
+	}
+	return "succeeded"
+}
+
+// episodeResult is the outcome of processing one episode
+type episodeResult struct {
+	Index    int
+	File     string
+	Meta     string
+	Duration float64
+	Streams  ffmpeg.StreamPlan
+	Subs     []EpisodeSubtitle
+	Reused   bool // the trimmed episode of an earlier job was reused
+	Err      error
+}
+
+// reusedFiles returns the trimmed episodes and metadata reused from earlier jobs
+func reusedFiles(results []episodeResult) map[string]bool {
+	files := make(map[string]bool)
+	for _, r := range results {
+		if r.Reused {
+			files[r.File] = true
+			files[r.Meta] = true
+		}
+	}
+	return files
+}
+
+// processEpisode scans, trims and (optionally) exports the subtitles of a single episode,
+// reporting the trim progress to tracker. The trimmed episode is named by naming's episode template.
+// An intact trimmed episode in reusable with the same
+// fingerprint is claimed from the job that trimmed it and used instead of trimming again
+func processEpisode(ctx context.Context, job *Job, tracker *phaseTracker, idx int, file string, ws Workspace, opts models.TrimOptions, naming Naming, reusable map[string]reusableEpisode) episodeResult {
+	ch, err := ffmpeg.ScanChapters(ctx, file)
+	if err != nil {
+		return episodeResult{Index: idx, Err: fmt.Errorf("scan failed: %v", err)}
+	}
+
+	// resolve the stream selection per episode so track layouts may differ between files
+	streams, err := ffmpeg.ResolveStreamPlan(ctx, file, opts)
+	if err != nil {
+		return episodeResult{Index: idx, Err: err}
+	}
+
+	keep := ffmpeg.ComputeKeepSegments(ch, opts.SkipRanges)
+	kept := 0.0
+	for _, seg := range keep {
+		kept += seg.End - seg.Start
+	}
+	tracker.SetWork(idx, kept)
+	cut, _ := ffmpeg.ResolveCutSettings(opts) // validated with the request
+	fingerprint, err := episodeFingerprint(file, keep, streams, cut)
+	if err != nil {
+		job.Logf("⚠️ [%02d] Cannot fingerprint %s: %v", idx+1, file, err)
+	}
+	job.UpdateEpisode(idx, func(ep *models.EpisodeStatus) {
+		ep.KeepSegments = keep
+		ep.Fingerprint = fingerprint
+	})
+
+	r := episodeResult{Index: idx, Streams: streams}
+	if prev, ok := reusable[fingerprint]; ok && fingerprint != "" {
+		if err := validateReusable(ctx, prev.EpisodeStatus); err != nil {
+			job.Logf("🔁 [%02d] Cannot reuse %s (%v), trimming again", idx+1, prev.Output, err)
+		} else if !claimReusable(prev) {
+			job.Logf("🔁 [%02d] %s was taken over by another job, trimming again", idx+1, prev.Output)
+		} else {
+			r.File, r.Meta, r.Duration, r.Reused = prev.Output, prev.Metadata, prev.Duration, true
+			ws.Track(prev.Output, prev.Metadata) // this job owns them from now on
+		}
+	}
+	if !r.Reused {
+		name, _ := naming.episodeName(idx+1, file) // the template was checked by ResolveNaming
+		r.File, r.Meta, r.Duration, err = ProcessSingleEpisode(ctx, file, ws, name, keep, streams, cut, tracker.Reporter(idx))
+		if err != nil {
+			return episodeResult{Index: idx, Err: fmt.Errorf("process failed: %v", err)}
+		}
+	}
+
+	if opts.ExportSubtitles {
+		// stream copy starts every segment at a keyframe, so the cues follow the real cut points
+		cuts := keep
+		if cut.Mode == ffmpeg.CutCopy {
+			cuts = copyCutSegments(ctx, file, keep)
+		}
+		r.Subs, err = ExtractEpisodeSubtitles(ctx, file, ws.WorkDir, cuts, opts.Subtitles)
+		if err != nil {
+			job.Logf("⚠️ [%02d] Subtitle export failed: %v", idx+1, err)
+		}
+	}
+	return r
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestJobOutcome(t *testing.T) {
+	part := []models.OutputFile{{Part: 1, Kind: "video", Path: "Part1.mkv"}}
+	ok := models.EpisodeStatus{State: "succeeded"}
+	failed := models.EpisodeStatus{State: "failed", Error: "process failed"}
+
+	tests := []struct {
+		name string
+		job  models.Job
+		want string
+	}{
+		{"all episodes merged", models.Job{Episodes: []models.EpisodeStatus{ok, ok}, Outputs: part}, "succeeded"},
+		{"episode left out", models.Job{Episodes: []models.EpisodeStatus{ok, failed}, Outputs: part}, "partial"},
+		{"merge stopped after a part", models.Job{Episodes: []models.EpisodeStatus{ok, ok}, Outputs: part, Error: "concat failed for part 2"}, "partial"},
+		{"no part written", models.Job{Episodes: []models.EpisodeStatus{ok}, Error: "concat failed for part 1"}, "failed"},
+		{"only sidecars", models.Job{Episodes: []models.EpisodeStatus{ok}, Outputs: []models.OutputFile{{Part: 1, Kind: "audio"}}}, "failed"},
+	}
+	for _, tt := range tests {
+		if got := jobOutcome(tt.job); got != tt.want {
+			t.Errorf("%s: jobOutcome = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
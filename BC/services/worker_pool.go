@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// WorkerPool bounds how many episodes are processed at once. Freed slots are handed out round-robin
+// across the owners (jobs) that are waiting, and in arrival order within an owner, so a job queued
+// behind a long one gets a slot as soon as one frees up instead of after all of the long job's episodes
+type WorkerPool struct {
+	mu      sync.Mutex
+	size    int
+	busy    int
+	waiting map[string][]*poolWaiter // waiters per owner, oldest first
+	owners  []string                 // owners with waiters, in the order slots are handed to them
+	next    int                      // position in owners of the owner served next
+}
+
+// poolWaiter is an Acquire call waiting for a slot; ready is closed once the slot is handed over
+type poolWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// Pool is the server-wide pool shared by all jobs
+var Pool = NewWorkerPool(DefaultWorkers())
+
+// DefaultWorkers is the default pool size: half the CPUs, since trims are mostly disk bound
+func DefaultWorkers() int {
+	return max(1, runtime.NumCPU()/2)
+}
+
+// NewWorkerPool creates a pool with n slots (at least one)
+func NewWorkerPool(n int) *WorkerPool {
+	return &WorkerPool{size: max(1, n), waiting: make(map[string][]*poolWaiter)}
+}
+
+// Size returns the number of slots
+func (p *WorkerPool) Size() int {
+	return p.size
+}
+
+// Acquire waits for a free slot for owner; it fails when ctx is done first
+func (p *WorkerPool) Acquire(ctx context.Context, owner string) error {
+	p.mu.Lock()
+	if p.busy < p.size && len(p.owners) == 0 {
+		p.busy++
+		p.mu.Unlock()
+		return nil
+	}
+	w := &poolWaiter{ready: make(chan struct{})}
+	if len(p.waiting[owner]) == 0 {
+		p.owners = append(p.owners, owner)
+	}
+	p.waiting[owner] = append(p.waiting[owner], w)
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		if w.granted {
+			// the slot arrived together with the cancellation; pass it on
+			p.releaseLocked()
+		} else {
+			p.dropLocked(owner, w)
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire, handing it to the next waiting owner
+func (p *WorkerPool) Release() {
+	p.mu.Lock()
+	p.releaseLocked()
+	p.mu.Unlock()
+}
+
+// releaseLocked hands the slot to the oldest waiter of the next owner in turn, or frees it; p.mu must be held
+func (p *WorkerPool) releaseLocked() {
+	if len(p.owners) == 0 {
+		p.busy--
+		return
+	}
+	p.next %= len(p.owners)
+	owner := p.owners[p.next]
+	w := p.waiting[owner][0]
+	p.waiting[owner] = p.waiting[owner][1:]
+	if len(p.waiting[owner]) == 0 {
+		delete(p.waiting, owner)
+		p.owners = append(p.owners[:p.next], p.owners[p.next+1:]...)
+	} else {
+		p.next++
+	}
+	w.granted = true
+	close(w.ready)
+}
+
+// dropLocked removes a cancelled waiter of owner; p.mu must be held
+func (p *WorkerPool) dropLocked(owner string, w *poolWaiter) {
+	queue := p.waiting[owner]
+	for i, q := range queue {
+		if q == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		p.waiting[owner] = queue
+		return
+	}
+	delete(p.waiting, owner)
+	for i, o := range p.owners {
+		if o == owner {
+			p.owners = append(p.owners[:i], p.owners[i+1:]...)
+			if i < p.next {
+				p.next--
+			}
+			break
+		}
+	}
+}
+
+// waiters returns how many Acquire calls are waiting
+func (p *WorkerPool) waiters() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, queue := range p.waiting {
+		n += len(queue)
+	}
+	return n
+}
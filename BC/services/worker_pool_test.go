@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolBoundsConcurrency(t *testing.T) {
+	pool := NewWorkerPool(2)
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pool.Acquire(context.Background(), "job"); err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			pool.Release()
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+}
+
+func TestWorkerPoolAcquireCancelled(t *testing.T) {
+	pool := NewWorkerPool(1)
+	if err := pool.Acquire(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Acquire(ctx, "b"); err != context.DeadlineExceeded {
+		t.Fatalf("Acquire on a full pool = %v, want deadline exceeded", err)
+	}
+	if pool.waiters() != 0 {
+		t.Error("the cancelled waiter is still queued")
+	}
+	// the slot is still handed out normally after the cancellation
+	pool.Release()
+	if err := pool.Acquire(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if NewWorkerPool(0).Size() != 1 {
+		t.Error("a pool needs at least one slot")
+	}
+}
+
+func TestWorkerPoolSharesSlotsBetweenJobs(t *testing.T) {
+	pool := NewWorkerPool(1)
+	if err := pool.Acquire(context.Background(), "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(owner string, n int) {
+		for i := 0; i < n; i++ {
+			want := pool.waiters() + 1
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := pool.Acquire(context.Background(), owner); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				order = append(order, owner)
+				mu.Unlock()
+				pool.Release()
+			}()
+			for pool.waiters() < want {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	// job A queues its remaining episodes first, then job B arrives
+	queue("A", 4)
+	queue("B", 2)
+	pool.Release()
+	wg.Wait()
+
+	want := []string{"A", "B", "A", "B", "A", "A"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("slots went to %v, want %v", order, want)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+	"github.com/sanke08/videoprocessor/utils"
+)
+
+// WorkRoot is the server-wide folder for the intermediates of every job, e.g. on a fast local disk while
+// outputs go to a NAS. Empty keeps them in each job's output folder
+var WorkRoot string
+
+// Workspace is where a job writes: parts and their sidecars go to Output, the trimmed episodes kept for
+// resuming (under names reserved across jobs) to Episodes, and segments and scratch files to the job's
+// private WorkDir. Final files are written to Staging and moved into
+// Output once verified, which copies them when Output is on another filesystem.
+// Track records intermediates created outside WorkDir in the job's manifest
+type Workspace struct {
+	Output   string
+	Episodes string
+	WorkDir  string
+	Staging  string
+	Track    func(paths ...string)
+}
+
+// ResolveWorkRoot returns the folder a job keeps its intermediates in: the request's workDir, else
+// WorkRoot, else the output folder
+func ResolveWorkRoot(output string, opts models.TrimOptions) (string, error) {
+	root := strings.TrimSpace(opts.WorkDir)
+	if root == "" {
+		root = WorkRoot
+	}
+	if root == "" {
+		return output, nil
+	}
+	if !filepath.IsAbs(root) {
+		return "", fmt.Errorf("workDir must be an absolute path, got %q", root)
+	}
+	return filepath.Clean(root), nil
+}
+
+// newWorkspace creates the job's private work folder inside its work root and records it in the manifest
+func newWorkspace(job *Job, output string) (Workspace, error) {
+	root, err := ResolveWorkRoot(output, job.Snapshot().Options)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workDir := filepath.Join(root, "tmp_job_"+job.ID())
+	staging := filepath.Join(workDir, "staging")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return Workspace{}, err
+	}
+	job.Track(workDir)
+	return Workspace{Output: output, Episodes: root, WorkDir: workDir, Staging: staging, Track: job.Track}, nil
+}
+
+// commitStaged checks with ffprobe that a staged file holds a readable stream of kind and moves it to dst.
+// A file that fails the check is removed, so a final name only ever holds a complete file
+func commitStaged(ctx context.Context, staged, dst, kind string) error {
+	if _, err := ffmpeg.VerifyOutput(ctx, staged, kind); err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("verifying %s failed: %v", filepath.Base(dst), err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	if err := utils.MoveFile(staged, dst); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return nil
+}
+
+// Track records files and folders the job created besides its outputs, so cleanup removes exactly those
+func (j *Job) Track(paths ...string) {
+	j.Update(func(state *models.Job) {
+		for _, p := range paths {
+			if p != "" && !slices.Contains(state.Intermediates, p) {
+				state.Intermediates = append(state.Intermediates, p)
+			}
+		}
+	})
+}
+
+// cleanupJob removes the job's intermediates except the files in keep and leaves only those in the
+// manifest. With keepIntermediates nothing is removed
+func cleanupJob(job *Job, keep map[string]bool) {
+	state := job.Snapshot()
+	if state.Options.KeepIntermediates {
+		job.Logf("🧰 Kept %d intermediate file(s) and folder(s) (keepIntermediates)", len(state.Intermediates))
+		return
+	}
+	left := removeIntermediates(state.Intermediates, keep)
+	job.Update(func(j *models.Job) {
+		j.Intermediates = left
+	})
+}
+
+// removeIntermediates removes every path except those in keep and returns the kept paths that still exist
+func removeIntermediates(paths []string, keep map[string]bool) []string {
+	var left []string
+	for _, p := range paths {
+		if !keep[p] {
+			os.RemoveAll(p)
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			left = append(left, p)
+		}
+	}
+	return left
+}
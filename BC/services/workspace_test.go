@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanke08/videoprocessor/ffmpeg"
+	"github.com/sanke08/videoprocessor/models"
+)
+
+func TestCleanupJobRemovesOnlyTrackedFiles(t *testing.T) {
+	for _, keepAll := range []bool{false, true} {
+		output := t.TempDir()
+		job := NewJobRegistry().Create("in", output, models.TrimOptions{KeepIntermediates: keepAll})
+		ws, err := newWorkspace(job, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		segment := filepath.Join(output, "Episode 1_seg_0_10.mkv")
+		episode := filepath.Join(output, "merged_Episode 1.mkv")
+		foreign := filepath.Join(output, "Episode 2_seg_0_10.mkv") // same pattern, another job's file
+		for _, f := range []string{segment, episode, foreign, filepath.Join(ws.WorkDir, "concat_list_1.txt")} {
+			if err := os.WriteFile(f, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ws.Track(segment, episode, "")
+		ws.Track(segment)
+		if got := job.Snapshot().Intermediates; !reflect.DeepEqual(got, []string{ws.WorkDir, segment, episode}) {
+			t.Fatalf("manifest = %q", got)
+		}
+
+		cleanupJob(job, map[string]bool{episode: true})
+		for f, wantKept := range map[string]bool{ws.WorkDir: keepAll, segment: keepAll, episode: true, foreign: true} {
+			if _, err := os.Stat(f); (err == nil) != wantKept {
+				t.Errorf("keepIntermediates=%v: %s exists = %v, want %v", keepAll, filepath.Base(f), err == nil, wantKept)
+			}
+		}
+		if !keepAll {
+			if got := job.Snapshot().Intermediates; !reflect.DeepEqual(got, []string{episode}) {
+				t.Errorf("manifest after cleanup = %q, want only the kept episode", got)
+			}
+		}
+	}
+}
+
+func TestNewWorkspaceUsesWorkRoot(t *testing.T) {
+	output, serverWork, jobWork := t.TempDir(), t.TempDir(), t.TempDir()
+	defer func(prev string) { WorkRoot = prev }(WorkRoot)
+
+	for _, c := range []struct {
+		serverRoot, jobRoot, want string
+	}{
+		{"", "", output},
+		{serverWork, "", serverWork},
+		{serverWork, jobWork, jobWork},
+	} {
+		WorkRoot = c.serverRoot
+		job := NewJobRegistry().Create("in", output, models.TrimOptions{WorkDir: c.jobRoot})
+		ws, err := newWorkspace(job, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Output != output || ws.Episodes != c.want || ws.WorkDir != filepath.Join(c.want, "tmp_job_"+job.ID()) {
+			t.Errorf("server %q, job %q: workspace = %+v", c.serverRoot, c.jobRoot, ws)
+		}
+		if info, err := os.Stat(ws.Staging); err != nil || !info.IsDir() {
+			t.Errorf("staging folder %s was not created", ws.Staging)
+		}
+	}
+
+	WorkRoot = ""
+	if _, err := ResolveWorkRoot(output, models.TrimOptions{WorkDir: "relative/work"}); err == nil {
+		t.Error("relative workDir accepted")
+	}
+}
+
+// requireFFmpeg skips tests that need ffmpeg and ffprobe
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+}
+
+// makeEpisode generates a 6s clip with a keyframe every second at path, size wide
+func makeEpisode(t *testing.T, path, size string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("ffmpeg", "-v", "error", "-y",
+		"-f", "lavfi", "-i", "testsrc2=size="+size+":rate=25",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-t", "6", "-c:v", "mpeg4", "-g", "25", "-c:a", "aac", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("generating %s failed: %v (%s)", path, err, out)
+	}
+}
+
+func TestConcurrentJobsShareWorkRoot(t *testing.T) {
+	requireFFmpeg(t)
+	defer func(prev string) { WorkRoot = prev }(WorkRoot)
+	WorkRoot = t.TempDir()
+	ctx := context.Background()
+
+	// two jobs trimming same-named episodes with the same segments into one work root
+	type run struct {
+		job  *Job
+		ws   Workspace
+		file string
+		size string
+	}
+	runs := []*run{{size: "320x240"}, {size: "160x120"}}
+	for i, r := range runs {
+		r.file = filepath.Join(t.TempDir(), "Episode 1.mkv")
+		makeEpisode(t, r.file, r.size)
+		output := filepath.Join(t.TempDir(), "out")
+		r.job = NewJobRegistry().Create(filepath.Dir(r.file), output, models.TrimOptions{})
+		var err error
+		if r.ws, err = newWorkspace(r.job, output); err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+
+	keep := []models.Segment{{Start: 0, End: 2}, {Start: 3, End: 5}}
+	results := make([][2]string, len(runs))
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streams, err := ffmpeg.ResolveStreamPlan(ctx, r.file, models.TrimOptions{})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			cut, _ := ffmpeg.ResolveCutSettings(models.TrimOptions{})
+			results[i][0], results[i][1], _, errs[i] = ProcessSingleEpisode(ctx, r.file, r.ws, "merged_Episode 1", keep, streams, cut, nil)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+	if results[0][0] == results[1][0] || (results[0][1] != "" && results[0][1] == results[1][1]) {
+		t.Fatalf("jobs share files: %q", results)
+	}
+
+	// each job's episode comes from its own source
+	for i, r := range runs {
+		out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+			"-show_entries", "stream=width,height", "-of", "csv=p=0:s=x", results[i][0]).Output()
+		if err != nil || strings.TrimSpace(string(out)) != r.size {
+			t.Errorf("job %d episode is %q (%v), want %s", i, out, err, r.size)
+		}
+	}
+
+	// cleaning up the first job leaves the second job's files alone
+	cleanupJob(runs[0].job, nil)
+	for _, f := range results[1] {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("second job's %s was removed: %v", filepath.Base(f), err)
+		}
+	}
+	if _, err := os.Stat(runs[1].ws.WorkDir); err != nil {
+		t.Errorf("second job's work folder was removed: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := FreeSpace(dir)
+	if err != nil || free <= 0 {
+		t.Errorf("FreeSpace = %d, %v", free, err)
+	}
+	if _, err := FreeSpace(filepath.Join(dir, "missing")); err == nil {
+		t.Error("FreeSpace of a missing folder succeeded")
+	}
+}
+
+func TestSameFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "work")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !SameFilesystem(dir, sub) {
+		t.Error("a folder and its subfolder are on different filesystems")
+	}
+	if SameFilesystem(dir, filepath.Join(dir, "missing")) {
+		t.Error("a missing path is on the same filesystem")
+	}
+}
@@ -0,0 +1,42 @@
+//go:build windows
+
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// FreeSpace returns the bytes available to the current user on the volume holding path
+func FreeSpace(path string) (int64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&free)))
+	if ok == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
+
+// SameFilesystem reports whether the paths a and b are on the same volume
+func SameFilesystem(a, b string) bool {
+	va := filepath.VolumeName(absPath(a))
+	vb := filepath.VolumeName(absPath(b))
+	return va != "" && strings.EqualFold(va, vb)
+}
+
+// absPath returns the absolute form of path, or path itself when that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
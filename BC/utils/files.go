@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ListMKVFiles returns the .mkv files directly inside folder in natural sort order.
+// The extension match is case-insensitive and hidden files (e.g. macOS "._" resource forks)
+// are skipped, so the same list is produced on Linux, macOS and Windows
+func ListMKVFiles(folder string) ([]string, error) {
+	folder = filepath.Clean(strings.TrimSpace(folder))
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || !strings.EqualFold(filepath.Ext(name), ".mkv") {
+			continue
+		}
+		if !e.Type().IsRegular() {
+			// follow symlinks but skip directories named like videos
+			info, err := os.Stat(filepath.Join(folder, name))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+		}
+		files = append(files, filepath.Join(folder, name))
+	}
+
+	// Use Natural Sort so "Episode 2" comes before "Episode 10"
+	sort.Slice(files, func(i, j int) bool {
+		return NaturalLess(files[i], files[j])
+	})
+	return files, nil
+}
+
+// MoveFile renames src to dst, replacing dst. When a rename is not possible (e.g. across filesystems)
+// src is copied next to dst under a temporary name, synced and renamed into place, so dst never holds
+// a partial file; src is removed afterwards
+func MoveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.part")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("moving %s to %s failed: %v", src, dst, err)
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// RemovePartialFiles removes the temporary copies an interrupted MoveFile left directly inside dirs
+// and returns how many were removed
+func RemovePartialFiles(dirs ...string) int {
+	removed := 0
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, ".*.part"))
+		for _, m := range matches {
+			if os.Remove(m) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
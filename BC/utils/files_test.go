@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListMKVFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Episode 10.mkv",
+		"Episode 2.mkv",
+		"Episode 1.MKV",
+		"._Episode 1.mkv",
+		".hidden.mkv",
+		"notes.txt",
+		"Episode 3.mp4",
+	} {
+		touch(t, filepath.Join(dir, name))
+	}
+	if err := os.Mkdir(filepath.Join(dir, "Extras.mkv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ListMKVFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "Episode 1.MKV"),
+		filepath.Join(dir, "Episode 2.mkv"),
+		filepath.Join(dir, "Episode 10.mkv"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListMKVFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestListMKVFilesTrimsAndCleansPath(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "a.mkv"))
+
+	got, err := ListMKVFiles("  " + dir + string(filepath.Separator) + "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != filepath.Join(dir, "a.mkv") {
+		t.Fatalf("ListMKVFiles() = %v", got)
+	}
+}
+
+func TestListMKVFilesFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "real.mkv")
+	touch(t, target)
+	if err := os.Symlink(target, filepath.Join(dir, "link.mkv")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	got, err := ListMKVFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != filepath.Join(dir, "link.mkv") {
+		t.Fatalf("ListMKVFiles() = %v", got)
+	}
+}
+
+func TestListMKVFilesMissingFolder(t *testing.T) {
+	if _, err := ListMKVFiles(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing folder")
+	}
+}
+
+func TestListMKVFilesEmptyFolder(t *testing.T) {
+	got, err := ListMKVFiles(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("ListMKVFiles() = %v, want empty", got)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "staged.mkv"), filepath.Join(dir, "Part1.mkv")
+	if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "new" {
+		t.Errorf("dst holds %q, want the moved file", data)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("src still exists")
+	}
+	if err := MoveFile(src, dst); err == nil {
+		t.Error("moving a missing file succeeded")
+	}
+}
+
+func TestRemovePartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, ".Part1.mkv.123.part"))
+	touch(t, filepath.Join(dir, "Part1.mkv"))
+	touch(t, filepath.Join(dir, "notes.part"))
+	if n := RemovePartialFiles(dir, filepath.Join(dir, "missing")); n != 1 {
+		t.Errorf("removed %d files, want 1", n)
+	}
+	for _, name := range []string{"Part1.mkv", "notes.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+}
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	other, err := os.MkdirTemp("/dev/shm", "movefile")
+	if err != nil {
+		t.Skip("no /dev/shm to move across filesystems")
+	}
+	defer os.RemoveAll(other)
+	src := filepath.Join(t.TempDir(), "staged.mkv")
+	if SameFilesystem(filepath.Dir(src), other) {
+		t.Skip("/dev/shm is on the same filesystem as the temp folder")
+	}
+	if err := os.WriteFile(src, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(other, "Part1.mkv")
+	if err := MoveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "part" {
+		t.Errorf("dst holds %q", data)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("dst mode = %v, %v", info.Mode(), err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("src still exists")
+	}
+	if left, _ := filepath.Glob(filepath.Join(other, ".*.part")); len(left) > 0 {
+		t.Errorf("temporary copies left: %v", left)
+	}
+}
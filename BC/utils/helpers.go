@@ -71,3 +71,9 @@ func MakeTrimFilename(outputDir, file string, start, end float64) string {
 	name := fmt.Sprintf("%s_seg_%.0f_%.0f.mkv", base, start, end)
 	return filepath.Join(outputDir, name)
 }
+
+// SanitizeFilename replaces spaces and characters that are invalid in file names on common filesystems
+func SanitizeFilename(name string) string {
+	replacer := strings.NewReplacer(" ", "_", "/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+	return replacer.Replace(strings.TrimSpace(name))
+}
@@ -0,0 +1,9 @@
+package utils
+
+import "testing"
+
+func TestSanitizeFilename(t *testing.T) {
+	if got := SanitizeFilename(` Dolby "Atmos": 5.1/7.1 `); got != "Dolby__Atmos___5.1_7.1" {
+		t.Errorf("SanitizeFilename() = %q", got)
+	}
+}
@@ -8,9 +8,17 @@ export interface AudioTrack {
     title: string;
 }
 
+export interface SubtitleTrack {
+    index: number;
+    lang: string;
+    title: string;
+    codec: string;
+}
+
 export interface ScanResult {
     chapters: Chapters;
     audioTracks: AudioTrack[];
+    subtitleTracks: SubtitleTrack[];
     firstFile: string;
 }
 
@@ -19,10 +27,51 @@ export interface SkipRange {
     end: string;
 }
 
+export interface AudioSelection {
+    mode: "all" | "index" | "language";
+    indexes?: number[];
+    languages?: string[];
+}
+
+export interface SubtitleSelection {
+    mode: "none" | "all" | "language";
+    languages?: string[];
+}
+
+export interface NamingOptions {
+    part?: string; // e.g. "{series} - Part {part:02}"
+    episode?: string;
+    series?: string;
+    season?: number;
+    collision?: "overwrite" | "suffix" | "fail";
+}
+
+export interface VerifyOptions {
+    tolerance?: string; // allowed part duration difference, default "1s"
+    decode?: boolean; // fully decode every part (slow)
+}
+
 export interface TrimOptions {
     skipRanges: SkipRange[];
     parts: number;
     audioIndex?: number;
+    audio?: AudioSelection;
+    subtitles?: SubtitleSelection;
+    exportSubtitles?: boolean;
+    exportAudio?: boolean;
+    audioExportFormat?: "copy" | "mka" | "aac" | "opus" | "flac" | "mp3";
+    cutMode?: "copy" | "smart" | "reencode";
+    preset?: string; // encoding preset for "reencode", see GET /api/presets
+    resume?: boolean;
+    concurrency?: number; // 0 = use the server-wide worker pool
+    grouping?: "count" | "balanced" | "duration";
+    partDuration?: string; // target part runtime for "duration", e.g. "2h"
+    naming?: NamingOptions;
+    keepIntermediates?: boolean;
+    workDir?: string; // absolute folder for intermediates, overrides the server's -work folder
+    concatPolicy?: "refuse" | "reencode" | "filter"; // parts whose episodes have mismatched streams
+    verify?: VerifyOptions;
+    diskCheck?: "refuse" | "warn" | "off"; // when the estimated disk space exceeds the free space
 }
 
 // Scan first episode